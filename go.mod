@@ -3,8 +3,13 @@ module github.com/xfali/magnet
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/fsnotify/fsnotify v1.4.9
+	github.com/klauspost/compress v1.11.13
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/ulikunitz/xz v0.5.11
 	github.com/xfali/goutils v0.0.6
 	github.com/xfali/stream v0.0.4
 	github.com/xfali/xlog v0.0.9
+	gopkg.in/yaml.v3 v3.0.1
 )
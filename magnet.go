@@ -8,11 +8,17 @@ package magnet
 import (
 	"errors"
 	"fmt"
+	"github.com/xfali/magnet/pkg/goplugin"
 	"github.com/xfali/magnet/pkg/installer"
+	"github.com/xfali/magnet/pkg/rpcplugin"
+	"github.com/xfali/magnet/pkg/supervisor"
 	"github.com/xfali/magnet/pkg/task"
 	"github.com/xfali/magnet/pkg/watcher"
 	"github.com/xfali/xlog"
+	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 const (
@@ -28,6 +34,8 @@ const (
 	InstallFlagUninstallOld = 1 << 3
 	// 当需要卸载时，使用异步方式卸载
 	InstallFlagAsyncUninstall = 1 << 4
+	// 安装后启动应用并等待健康检查通过，未通过则停止应用并清理安装，安装包未声明健康检查时仅启动应用
+	InstallFlagWaitHealthy = 1 << 5
 )
 
 type Magnet struct {
@@ -36,12 +44,27 @@ type Magnet struct {
 	recorder   installer.Recorder
 	listener   watcher.PackageListener
 	watcherFac watcher.Factory
+	supervisor supervisor.Supervisor
+	// 默认Supervisor的配置
+	supervisorOpts []supervisor.Opt
+	// 默认Installer的安装目录及配置
+	installDir    string
+	installerOpts []installer.Opt
+	userPolicy    installer.UserPolicy
+	// 文件更新时重启应用的防抖时间，为0则不启用
+	restartDebounce time.Duration
+	restartListener watcher.PackageListener
 
 	taskCtrl task.Controller
 	log      xlog.Logger
 	watchers map[string]watcher.Watcher
+	// 已加载的Go插件
+	plugins map[string]*goplugin.Plugin
+	// 运行中的进程外插件
+	rpcPlugins map[string]*rpcplugin.Client
 
-	watchLock sync.Mutex
+	watchLock  sync.Mutex
+	pluginLock sync.Mutex
 }
 
 type Opt func(m *Magnet)
@@ -51,6 +74,8 @@ func New(opts ...Opt) *Magnet {
 		strategy:   installer.NewStrategy(),
 		watcherFac: watcher.NewWatcher,
 		watchers:   map[string]watcher.Watcher{},
+		plugins:    map[string]*goplugin.Plugin{},
+		rpcPlugins: map[string]*rpcplugin.Client{},
 
 		taskCtrl: task.NewController(),
 		log:      xlog.GetLogger(),
@@ -58,6 +83,45 @@ func New(opts ...Opt) *Magnet {
 	for i := range opts {
 		opts[i](ret)
 	}
+	if ret.installer == nil && ret.installDir != "" {
+		var err error
+		ret.installer, err = installer.CreateRegistry(ret.installDir, ret.installerOpts...)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if ret.supervisor == nil {
+		opts := append([]supervisor.Opt{supervisor.SetLogger(ret.log)}, ret.supervisorOpts...)
+		ret.supervisor = supervisor.NewSupervisor(opts...)
+	}
+	if ret.restartDebounce > 0 {
+		ret.restartListener = supervisor.NewRestartListener(ret.supervisor, ret.restartDebounce)
+	}
+	if l, ok := ret.listener.(watcher.ProcessListener); ok {
+		ret.supervisor.AddListener(l)
+	}
+	if ret.recorder != nil {
+		// 接管宿主重启前启动且仍在运行的应用
+		err := ret.supervisor.Adopt(ret.recorder.ListPackage()...)
+		if err != nil {
+			ret.log.Errorf("Adopt running packages error: %v\n", err)
+		}
+		// 重新加载已安装的Go插件及进程外插件
+		for _, pkg := range ret.recorder.ListPackage() {
+			if pkg == nil {
+				continue
+			}
+			var err error
+			if isGoPlugin(pkg) {
+				err = ret.loadPlugin(pkg)
+			} else if isRPCPlugin(pkg) {
+				err = ret.startRPCPlugin(pkg)
+			}
+			if err != nil {
+				ret.log.Errorf("Load plugin: %s error: %v\n", pkg.GetName(), err)
+			}
+		}
+	}
 	return ret
 }
 
@@ -68,7 +132,8 @@ func (m *Magnet) Close() error {
 		v.Stop()
 	}
 	m.watchLock.Unlock()
-	return nil
+	m.closeRPCPlugins()
+	return m.supervisor.Close()
 }
 
 // 获得安装包信息
@@ -84,14 +149,84 @@ func (m *Magnet) Install(path string, flag int) (installer.Package, error) {
 	if err != nil {
 		return nil, err
 	}
+	handle, exists, err := m.prepareInstall(info, flag)
+	if err != nil {
+		return exists, err
+	}
+	defer handle.Done()
 
-	handle, err := m.taskCtrl.AddTask(info.GetName())
+	pkg, err := m.installer.Install(path, m.strategy)
+	if err != nil {
+		if pkg != nil {
+			pkg.Uninstall(true)
+		}
+		return nil, err
+	}
+	return m.completeInstall(pkg, flag)
+}
+
+// 从数据流安装，如通过HTTP接收的安装包，安装标志与Install相同，Installer需要实现installer.StreamInstaller
+// 安装包信息在读取数据流后才能获得，安装标志的检查在Installer生成安装路径前进行
+// param： r安装包数据流，size数据长度，未知时为-1， flag 安装标志
+func (m *Magnet) InstallFrom(r io.Reader, size int64, flag int) (installer.Package, error) {
+	si, ok := m.installer.(installer.StreamInstaller)
+	if !ok {
+		return nil, errors.New("Installer does not support install from stream ")
+	}
+	s := &installStrategy{
+		m:        m,
+		flag:     flag,
+		strategy: m.strategy,
+	}
+	pkg, err := si.InstallFrom(r, size, s)
+	if s.handle != nil {
+		defer s.handle.Done()
+	}
 	if err != nil {
+		if pkg != nil {
+			pkg.Uninstall(true)
+		}
 		return nil, err
 	}
+	return m.completeInstall(pkg, flag)
+}
+
+// 在生成安装路径前按安装标志检查已安装的应用
+type installStrategy struct {
+	m        *Magnet
+	flag     int
+	strategy installer.Strategy
+	handle   task.Handle
+}
+
+func (s *installStrategy) GenInstallPath(dir string, pkgInfo installer.PackageInfo) (string, error) {
+	handle, _, err := s.m.prepareInstall(pkgInfo, s.flag)
+	if err != nil {
+		return "", err
+	}
+	s.handle = handle
+	return s.strategy.GenInstallPath(dir, pkgInfo)
+}
+
+// 检查用户策略、添加安装任务，并按安装标志卸载已安装的应用
+// return: 安装任务，安装完成后需调用Done；不能安装时返回错误，存在更新的版本时同时返回该版本
+func (m *Magnet) prepareInstall(info installer.PackageInfo, flag int) (task.Handle, installer.Package, error) {
+	err := installer.CheckUser(m.userPolicy, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 在卸载已安装的版本及写入安装目录前检查，安装失败时会删除安装目录
+	err = m.checkPluginReload(info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handle, err := m.taskCtrl.AddTask(info.GetName())
+	if err != nil {
+		return nil, nil, err
+	}
 	// for install sub task
 	handle.Add(1)
-	defer handle.Done()
 
 	if flag&InstallFlagForce == 0 {
 		pkgs := m.recorder.GetPackage(info.GetName())
@@ -104,7 +239,8 @@ func (m *Magnet) Install(path string, flag int) (installer.Package, error) {
 					for _, pkg := range pkgs {
 						//安装包比现有安装更老
 						if info.GetVersion() <= pkg.GetVersion() {
-							return pkg, fmt.Errorf("Package: %s Exists version: %d is Newer than Install version %d ",
+							handle.Done()
+							return nil, pkg, fmt.Errorf("Package: %s Exists version: %d is Newer than Install version %d ",
 								pkg.GetName(), pkg.GetVersion(), info.GetVersion())
 						} else {
 							if flag&InstallFlagUninstallOld != 0 {
@@ -115,35 +251,59 @@ func (m *Magnet) Install(path string, flag int) (installer.Package, error) {
 				} else {
 					// 默认非删除所有已存在安装包及非更新安装都选择不安装
 					// 由于同名程序已存在，不做卸载处理可能出现问题。
-					return nil, errors.New("Package: " + info.GetName() + " Exists")
+					handle.Done()
+					return nil, nil, errors.New("Package: " + info.GetName() + " Exists")
 				}
 			} else {
 				pkg2remove = pkgs
 			}
 			if len(pkg2remove) > 0 {
+				// 每个卸载的安装包完成时调用一次Done
+				handle.Add(len(pkg2remove))
 				if flag&InstallFlagAsyncUninstall != 0 {
-					go m.uninstallPkgs(handle, false, pkg2remove...)
+					go m.uninstallPkgs(handle, false, true, pkg2remove...)
 				} else {
-					m.uninstallPkgs(handle, false, pkg2remove...)
+					m.uninstallPkgs(handle, false, true, pkg2remove...)
 				}
 			}
 		}
 	}
+	return handle, nil, nil
+}
 
-	pkg, err := m.installer.Install(path, m.strategy)
+// 安装完成后加载插件或按安装标志启动应用，并记录安装信息、监听安装目录
+// 进程外插件已运行时替换为新安装的插件进程
+func (m *Magnet) completeInstall(pkg installer.Package, flag int) (installer.Package, error) {
+	var err error
+	if isGoPlugin(pkg) {
+		err = m.loadPlugin(pkg)
+	} else if isRPCPlugin(pkg) {
+		err = m.startRPCPlugin(pkg)
+	}
 	if err != nil {
-		if pkg != nil {
+		pkg.Uninstall(true)
+		return nil, err
+	}
+	if flag&InstallFlagWaitHealthy != 0 {
+		err := m.startHealthy(pkg)
+		if err != nil {
 			pkg.Uninstall(true)
 			return nil, err
 		}
 	}
+
 	err = m.recorder.Save(pkg)
 	if err != nil {
 		return nil, err
 	}
 
 	w := m.watcherFac()
-	w.AddListener(m.listener)
+	if m.listener != nil {
+		w.AddListener(m.listener)
+	}
+	if m.restartListener != nil {
+		w.AddListener(m.restartListener)
+	}
 	w.Watch(pkg)
 
 	m.watchLock.Lock()
@@ -153,6 +313,40 @@ func (m *Magnet) Install(path string, flag int) (installer.Package, error) {
 	return pkg, nil
 }
 
+func (m *Magnet) startHealthy(pkg installer.Package) error {
+	// Go插件没有健康检查，调用Start即可
+	if isGoPlugin(pkg) {
+		return m.callPlugin(pkg.GetName(), goplugin.SymbolStart)
+	}
+	// 进程外插件在安装后已启动并完成握手
+	if isRPCPlugin(pkg) {
+		return nil
+	}
+	// 安装失败时pkg.Uninstall只删除安装目录，由此删除本次启动创建的日志及数据目录
+	// 已存在的目录属于之前安装的版本，需要保留
+	var created []string
+	for _, dir := range []string{supervisor.LogDir(pkg.GetInstallPath()), supervisor.DataDir(pkg.GetInstallPath())} {
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			created = append(created, dir)
+		}
+	}
+	err := m.supervisor.Start(pkg)
+	if err == nil {
+		err = m.supervisor.WaitHealthy(pkg.GetName())
+		if err != nil {
+			m.log.Errorf("Package: %s health check failed: %v\n", pkg.GetName(), err)
+			m.supervisor.Stop(pkg.GetName())
+		}
+	}
+	if err != nil {
+		for _, dir := range created {
+			os.RemoveAll(dir)
+		}
+		return err
+	}
+	return nil
+}
+
 // 卸载安装
 // param: name 安装包名称， delPkg 是否卸载同时删除安装包
 func (m *Magnet) Uninstall(name string, delPkg bool) error {
@@ -170,15 +364,37 @@ func (m *Magnet) UninstallPkgs(delPkg bool, pkgs ...installer.Package) (err erro
 			return err
 		}
 		h.Add(len(pkgs))
-		return m.uninstallPkgs(h, delPkg, pkgs...)
+		return m.uninstallPkgs(h, delPkg, false, pkgs...)
 	}
 	return nil
 }
 
-func (m *Magnet) uninstallOne(handle task.Handle, delPkg bool, pkg installer.Package) (err error) {
+// upgrade为true时为安装新版本前卸载旧版本，进程外插件继续运行，安装完成后替换为新版本的进程
+func (m *Magnet) uninstallOne(handle task.Handle, delPkg, upgrade bool, pkg installer.Package) (err error) {
 	defer handle.Done()
 
 	m.log.Infof("Uninstall package: %s Exists version: %d delPkg: %d\n", pkg.GetName(), pkg.GetVersion(), delPkg)
+	// 删除文件前先停止正在运行的应用
+	st, serr := m.supervisor.Status(pkg.GetName())
+	if isGoPlugin(pkg) {
+		err = m.unloadPlugin(pkg)
+		if err != nil {
+			return err
+		}
+	} else if isRPCPlugin(pkg) {
+		if !upgrade {
+			err = m.stopRPCPlugin(pkg.GetName())
+			if err != nil {
+				return err
+			}
+		}
+	} else if serr == nil && st.InstallPath == pkg.GetInstallPath() &&
+		(st.State == supervisor.StateRunning || st.State == supervisor.StateBackoff || st.State == supervisor.StateScheduled) {
+		err = m.supervisor.Stop(pkg.GetName())
+		if err != nil {
+			return err
+		}
+	}
 	err = pkg.Uninstall(delPkg)
 	if err != nil {
 		return err
@@ -197,9 +413,9 @@ func (m *Magnet) uninstallOne(handle task.Handle, delPkg bool, pkg installer.Pac
 	return m.recorder.Remove(pkg)
 }
 
-func (m *Magnet) uninstallPkgs(handle task.Handle, delPkg bool, pkgs ...installer.Package) error {
+func (m *Magnet) uninstallPkgs(handle task.Handle, delPkg, upgrade bool, pkgs ...installer.Package) error {
 	for _, pkg := range pkgs {
-		err := m.uninstallOne(handle, delPkg, pkg)
+		err := m.uninstallOne(handle, delPkg, upgrade, pkg)
 		if err != nil {
 			m.log.Infof("Uninstall package: %s error: %v\n", pkg.GetName(), err)
 			continue
@@ -218,6 +434,77 @@ func (m *Magnet) ListPackage() []installer.Package {
 	return m.recorder.ListPackage()
 }
 
+// 启动已安装的应用，存在多个版本时启动最新版本，Go插件调用插件的Start，进程外插件启动插件进程
+func (m *Magnet) Start(name string) error {
+	pkg := m.latestPackage(name)
+	if pkg == nil {
+		return errors.New("Package: " + name + " not found ")
+	}
+	if isGoPlugin(pkg) {
+		return m.callPlugin(name, goplugin.SymbolStart)
+	}
+	if isRPCPlugin(pkg) {
+		if _, err := m.RPCPlugin(name); err == nil {
+			return errors.New("Plugin: " + name + " is running ")
+		}
+		return m.startRPCPlugin(pkg)
+	}
+	return m.supervisor.Start(pkg)
+}
+
+// 停止正在运行的应用，Go插件调用插件的Stop，进程外插件停止插件进程
+func (m *Magnet) Stop(name string) error {
+	if _, err := m.Plugin(name); err == nil {
+		return m.callPlugin(name, goplugin.SymbolStop)
+	}
+	if _, err := m.RPCPlugin(name); err == nil {
+		return m.stopRPCPlugin(name)
+	}
+	return m.supervisor.Stop(name)
+}
+
+// 查询应用运行状态，包括退出码及重启次数
+func (m *Magnet) Status(name string) (supervisor.Status, error) {
+	return m.supervisor.Status(name)
+}
+
+// 获得定时任务最近的运行记录，包括启动时间、结束时间及退出码
+func (m *Magnet) Runs(name string) ([]supervisor.Run, error) {
+	return m.supervisor.Runs(name)
+}
+
+// 获得应用的运行参数，包括展开变量后的启动命令及环境变量，用于调试
+func (m *Magnet) ExecSpec(name string) (*supervisor.ExecSpec, error) {
+	pkg := m.latestPackage(name)
+	if pkg == nil {
+		return nil, errors.New("Package: " + name + " not found ")
+	}
+	return m.supervisor.ExecSpec(pkg)
+}
+
+// 获得应用的输出日志，日志保存在安装目录旁的日志目录中
+// param: since只返回该时间之后的日志，零值返回所有日志，follow为true则持续输出新增日志直到关闭
+func (m *Magnet) Logs(name string, since time.Time, follow bool) (io.ReadCloser, error) {
+	pkg := m.latestPackage(name)
+	if pkg == nil {
+		return nil, errors.New("Package: " + name + " not found ")
+	}
+	return m.supervisor.Logs(pkg, since, follow)
+}
+
+func (m *Magnet) latestPackage(name string) installer.Package {
+	var ret installer.Package
+	for _, pkg := range m.recorder.GetPackage(name) {
+		if pkg == nil {
+			continue
+		}
+		if ret == nil || pkg.GetVersion() > ret.GetVersion() {
+			ret = pkg
+		}
+	}
+	return ret
+}
+
 // 设置安装策略，控制安装的行为
 func SetInstallStrategy(s installer.Strategy) Opt {
 	return func(m *Magnet) {
@@ -240,6 +527,7 @@ func SetRecorder(r installer.Recorder) Opt {
 }
 
 // 设置安装应用监听器，用于监听安装应用的状态，包括更新、删除
+// 如果监听器实现了watcher.ProcessListener，还将收到应用进程启动、退出及重启的事件，实现了watcher.FailureListener时还将收到应用不再重启的事件
 func SetListener(l watcher.PackageListener) Opt {
 	return func(m *Magnet) {
 		m.listener = l
@@ -260,19 +548,81 @@ func SetWatchFactory(fac watcher.Factory) Opt {
 	}
 }
 
+// 设置应用进程管理器，用于启动、停止已安装的应用
+func SetSupervisor(s supervisor.Supervisor) Opt {
+	return func(m *Magnet) {
+		m.supervisor = s
+	}
+}
+
+// 设置宿主的环境变量，覆盖安装包中声明的同名环境变量
+// 仅对默认的Supervisor生效，使用SetSupervisor时请通过supervisor.SetEnv设置
+func SetEnv(env map[string]string) Opt {
+	return func(m *Magnet) {
+		m.supervisorOpts = append(m.supervisorOpts, supervisor.SetEnv(env))
+	}
+}
+
+// 设置应用cgroup的父节点，需位于cgroup v2层级下且可写，为空则仅使用setrlimit限制资源
+// 仅对默认的Supervisor生效，使用SetSupervisor时请通过supervisor.SetCgroupParent设置
+func SetCgroupParent(parent string) Opt {
+	return func(m *Magnet) {
+		m.supervisorOpts = append(m.supervisorOpts, supervisor.SetCgroupParent(parent))
+	}
+}
+
+// 设置用户策略，限制安装包可以声明的运行用户及用户组，默认不限制
+// 安装及默认的Supervisor启动应用时都将检查该策略
+func SetUserPolicy(p installer.UserPolicy) Opt {
+	return func(m *Magnet) {
+		m.userPolicy = p
+		m.supervisorOpts = append(m.supervisorOpts, supervisor.SetUserPolicy(p))
+	}
+}
+
+// 启用文件更新时重启应用，可执行文件或者安装包声明的配置文件更新后重启应用或发送reloadSignal
+// param: debounce 防抖时间，该时间内的多次更新只触发一次重启
+func SetRestartOnChange(debounce time.Duration) Opt {
+	return func(m *Magnet) {
+		m.restartDebounce = debounce
+	}
+}
+
+// 设置进程状态文件，记录运行中应用的pid及启动时间，宿主重启后将接管仍在运行的应用
+// 仅对默认的Supervisor生效，使用SetSupervisor时请通过supervisor.SetStateFile设置
+func SetStateFile(path string) Opt {
+	return func(m *Magnet) {
+		m.supervisorOpts = append(m.supervisorOpts, supervisor.SetStateFile(path))
+	}
+}
+
+// 设置目录安装包使用硬链接代替复制，仅对默认的Installer生效
+func SetHardlink(enable bool) Opt {
+	return func(m *Magnet) {
+		m.installerOpts = append(m.installerOpts, installer.SetHardlink(enable))
+	}
+}
+
+// 设置安装包的解压限制，如解压后的总大小、文件个数、压缩比等，仅对默认的Installer生效
+func SetExtractLimits(l installer.ExtractLimits) Opt {
+	return func(m *Magnet) {
+		m.installerOpts = append(m.installerOpts, installer.SetExtractLimits(l))
+	}
+}
+
 // 使用默认配置，包括Installer、Recorder、WatcherFactory、Listener
+// Installer根据文件头识别安装包格式，支持zip、tar（包括gzip、xz、zstd压缩）、OCI镜像布局及包含描述文件的目录
+// 进程状态文件位于安装记录文件旁，文件名为recordFile.proc
 func Default(installDir, recordFile string) Opt {
 	return func(m *Magnet) {
 		var err error
-		m.installer, err = installer.CreateInstaller(installDir)
-		if err != nil {
-			panic(err)
-		}
+		m.installDir = installDir
 		m.recorder, err = installer.CreateRecorder(recordFile)
 		if err != nil {
 			panic(err)
 		}
 		m.watcherFac = watcher.NewWatcher
 		m.listener = &watcher.DummyListener{}
+		m.supervisorOpts = append(m.supervisorOpts, supervisor.SetStateFile(recordFile+".proc"))
 	}
 }
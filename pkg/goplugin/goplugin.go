@@ -0,0 +1,223 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package goplugin
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"plugin"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// 插件的生命周期符号，类型为func() error或func()
+const (
+	SymbolInit  = "Init"
+	SymbolStart = "Start"
+	SymbolStop  = "Stop"
+)
+
+// 未声明符号时查找的生命周期符号，不存在时忽略
+var DefaultSymbols = []string{SymbolInit, SymbolStart, SymbolStop}
+
+// 插件与宿主使用的Go工具链或依赖模块版本不一致，插件需使用与宿主相同的Go版本、编译参数及依赖版本重新编译
+type VersionError struct {
+	// 插件路径
+	Path string
+	// 版本不一致的包
+	Package string
+	// 是否为标准库的包，即Go工具链不一致
+	Toolchain bool
+	Err       error
+}
+
+func (e *VersionError) Error() string {
+	if e.Toolchain {
+		return "Plugin: " + e.Path + " built with a different Go toolchain than host " + runtime.Version() +
+			" (package " + e.Package + "): " + e.Err.Error()
+	}
+	return "Plugin: " + e.Path + " built with a different version of package " + e.Package +
+		" than host, check module versions in go.mod: " + e.Err.Error()
+}
+
+func (e *VersionError) Unwrap() error {
+	return e.Err
+}
+
+// 插件已加载，无法替换为新的插件文件（如升级），Go插件无法卸载，需重启宿主才能加载新的插件
+type ReloadError struct {
+	// 插件路径
+	Path string
+	Err  error
+}
+
+func (e *ReloadError) Error() string {
+	msg := "Plugin: " + e.Path + " is already loaded and cannot be replaced, restart host to load new plugin"
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ReloadError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// 已加载的插件文件的SHA-256，plugin.Open按路径缓存插件，文件被替换后仍返回旧的插件
+	loaded     = map[string][sha256.Size]byte{}
+	loadedLock sync.Mutex
+)
+
+// 已加载的Go插件
+// Go插件加载后无法卸载，同一插件（相同的包路径）只能加载一次
+type Plugin struct {
+	Name string
+	Path string
+
+	symbols map[string]plugin.Symbol
+	lock    sync.Mutex
+}
+
+// 打开插件并查找符号
+// param: name插件名称，path插件路径，symbols需要查找的符号，不存在时返回错误，为空时查找DefaultSymbols中存在的符号
+func Open(name, path string, symbols []string) (*Plugin, error) {
+	p, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Plugin{
+		Name:    name,
+		Path:    path,
+		symbols: map[string]plugin.Symbol{},
+	}
+	required := len(symbols) > 0
+	if !required {
+		symbols = DefaultSymbols
+	}
+	for _, s := range symbols {
+		sym, err := p.Lookup(s)
+		if err != nil {
+			if required {
+				return nil, errors.New("Plugin: " + path + " symbol: " + s + " not found ")
+			}
+			continue
+		}
+		ret.symbols[s] = sym
+	}
+	return ret, nil
+}
+
+// 打开插件，插件文件在加载后被替换时返回ReloadError
+func open(path string) (*plugin.Plugin, error) {
+	// 与plugin.Open相同，按真实路径缓存
+	realPath, err := filepath.Abs(path)
+	if err == nil {
+		realPath, err = filepath.EvalSymlinks(realPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fileSum(realPath)
+	if err != nil {
+		return nil, err
+	}
+
+	loadedLock.Lock()
+	defer loadedLock.Unlock()
+	if old, ok := loaded[realPath]; ok && old != sum {
+		return nil, &ReloadError{Path: path}
+	}
+	p, err := plugin.Open(realPath)
+	if err != nil {
+		return nil, openError(path, err)
+	}
+	loaded[realPath] = sum
+	return p, nil
+}
+
+func fileSum(path string) ([sha256.Size]byte, error) {
+	var ret [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return ret, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return ret, err
+	}
+	copy(ret[:], h.Sum(nil))
+	return ret, nil
+}
+
+// 识别插件版本不一致及重复加载的错误
+func openError(path string, err error) error {
+	const mismatch = "different version of package "
+	msg := err.Error()
+	// 相同包路径的插件已从其他路径加载
+	if strings.HasSuffix(msg, "plugin already loaded") {
+		return &ReloadError{Path: path, Err: err}
+	}
+	i := strings.Index(msg, mismatch)
+	if i < 0 {
+		return err
+	}
+	pkg := strings.TrimSpace(msg[i+len(mismatch):])
+	// 标准库的包路径第一段不包含"."
+	first := strings.SplitN(pkg, "/", 2)[0]
+	return &VersionError{
+		Path:      path,
+		Package:   pkg,
+		Toolchain: !strings.Contains(first, "."),
+		Err:       err,
+	}
+}
+
+// 获得已加载的符号
+func (p *Plugin) Lookup(name string) (plugin.Symbol, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s, ok := p.symbols[name]
+	return s, ok
+}
+
+// 获得已加载的符号表
+func (p *Plugin) Symbols() map[string]plugin.Symbol {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ret := make(map[string]plugin.Symbol, len(p.symbols))
+	for k, v := range p.symbols {
+		ret[k] = v
+	}
+	return ret
+}
+
+// 调用生命周期符号，符号不存在时忽略
+func (p *Plugin) Call(name string) error {
+	s, ok := p.Lookup(name)
+	if !ok {
+		return nil
+	}
+	switch f := s.(type) {
+	case func() error:
+		return f()
+	case *func() error:
+		return (*f)()
+	case func():
+		f()
+		return nil
+	case *func():
+		(*f)()
+		return nil
+	}
+	return errors.New("Plugin: " + p.Path + " symbol: " + name + " is not func() or func() error ")
+}
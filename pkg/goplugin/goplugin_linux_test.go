@@ -0,0 +1,157 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package goplugin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const pluginSource = `package main
+
+var Count int
+
+func Init() error {
+	Count++
+	return nil
+}
+
+func Start() {
+	Count += 10
+}
+
+func Hello(name string) string {
+	return "hello " + name
+}
+
+func main() {}
+`
+
+func buildPlugin(t *testing.T, module string, args ...string) string {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	dir, err := ioutil.TempDir("", "magnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+module+"\n\ngo 1.14\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(pluginSource), 0644)
+	out := filepath.Join(dir, module+".so")
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	cmd := exec.Command(gobin, append(append([]string{"build", "-buildmode=plugin", "-o", out}, args...), ".")...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GO111MODULE=on", "CGO_ENABLED=1")
+	d, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Skip("build plugin failed: ", string(d))
+	}
+	return out
+}
+
+func TestOpen(t *testing.T) {
+	path := buildPlugin(t, "hello")
+	_, err := Open("hello", path, []string{SymbolInit, SymbolStop})
+	if err == nil {
+		t.Fatal("expect symbol Stop not found")
+	}
+	t.Log(err)
+
+	p, err := Open("hello", path, []string{SymbolInit, SymbolStart, "Hello", "Count"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Symbols()) != 4 {
+		t.Fatal("expect 4 symbols, got ", len(p.Symbols()))
+	}
+	err = p.Call(SymbolInit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Call(SymbolStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未加载的符号忽略
+	err = p.Call(SymbolStop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, _ := p.Lookup("Count")
+	if *count.(*int) != 11 {
+		t.Fatal("expect 11, got ", *count.(*int))
+	}
+	hello, _ := p.Lookup("Hello")
+	if hello.(func(string) string)("magnet") != "hello magnet" {
+		t.Fatal("unexpected result")
+	}
+	if p.Call("Hello") == nil {
+		t.Fatal("expect signature error")
+	}
+
+	// 未声明符号时只加载存在的生命周期符号
+	p, err = Open("hello", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Symbols()) != 2 {
+		t.Fatal("expect Init and Start, got ", p.Symbols())
+	}
+}
+
+func TestOpenMismatch(t *testing.T) {
+	// 禁用优化及内联后标准库的包与宿主不一致
+	path := buildPlugin(t, "mismatch", "-gcflags=all=-N -l")
+	_, err := Open("mismatch", path, nil)
+	var verr *VersionError
+	if !errors.As(err, &verr) {
+		t.Fatal("expect VersionError, got ", err)
+	}
+	if !verr.Toolchain {
+		t.Fatal("expect toolchain mismatch, got ", verr.Package)
+	}
+	t.Log(err)
+}
+
+func TestOpenReplaced(t *testing.T) {
+	path := buildPlugin(t, "replaced")
+	_, err := Open("replaced", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 文件未改变时返回已加载的插件
+	_, err = Open("replaced", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 替换为新编译的插件
+	d, err := ioutil.ReadFile(buildPlugin(t, "replaced2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	err = ioutil.WriteFile(path, d, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open("replaced", path, nil)
+	var rerr *ReloadError
+	if !errors.As(err, &rerr) {
+		t.Fatal("expect ReloadError, got ", err)
+	}
+	t.Log(err)
+}
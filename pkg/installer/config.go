@@ -0,0 +1,37 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+// Installer的配置
+type config struct {
+	userPolicy UserPolicy
+	hardlink   bool
+	limits     ExtractLimits
+}
+
+type Opt func(c *config)
+
+// 设置用户策略，限制安装包可以声明的用户及用户组，默认不限制
+func SetUserPolicy(p UserPolicy) Opt {
+	return func(c *config) {
+		c.userPolicy = p
+	}
+}
+
+// 目录安装包使用硬链接代替复制，无法创建硬链接时（如跨文件系统）仍使用复制
+func SetHardlink(enable bool) Opt {
+	return func(c *config) {
+		c.hardlink = enable
+	}
+}
+
+// 设置解压限制，默认为DefaultExtractLimits，字段为0则不限制
+// 同样用于读取安装包信息及复制目录安装包
+func SetExtractLimits(l ExtractLimits) Opt {
+	return func(c *config) {
+		c.limits = l
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// 目录安装包，安装包为包含描述文件的未打包目录，用于本地开发调试
+// 默认复制目录中的文件，可以通过SetHardlink使用硬链接，安装包目录在卸载时不会被删除
+type DirInstaller struct {
+	baseInstaller
+}
+
+func CreateDirInstaller(installDir string, opts ...Opt) (*DirInstaller, error) {
+	base, err := newBaseInstaller(installDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &DirInstaller{base}, nil
+}
+
+func (inst *DirInstaller) ReadInfo(path string) (PackageInfo, error) {
+	return getDirPackageInfo(path)
+}
+
+func (inst *DirInstaller) Install(path string, strategy Strategy) (Package, error) {
+	info, err := getDirPackageInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := inst.installStaged(&ZipPackage{KeepPkg: true}, path, strategy, func(staging string) (*ZipPackageInfo, error) {
+		uid, gid, err := LookupOwner(info.User, info.Group)
+		if err != nil {
+			return nil, err
+		}
+		// 硬链接的文件与安装包目录共享所有者，声明了运行用户时使用复制
+		link := inst.conf.hardlink && uid < 0 && gid < 0
+		return info, copyDir(path, staging, inst.installDir, link, info, newLimiter(inst.conf.limits))
+	})
+	if pkg == nil {
+		return nil, err
+	}
+	return pkg, err
+}
+
+func (inst *DirInstaller) Uninstall(pkg Package, del bool) error {
+	return pkg.Uninstall(del)
+}
+
+func getDirPackageInfo(path string) (*ZipPackageInfo, error) {
+	for _, name := range ManifestFileNames {
+		d, err := ioutil.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		return ParseManifest(name, d)
+	}
+	return nil, errors.New("pkg.info not found")
+}
+
+// 复制目录，保留文件权限、修改时间及符号链接，只复制当前平台的平台目录
+// skip为跳过的目录，如位于安装包目录中的安装目录；符号链接指向目录之外时返回*UnsafePathError，
+// 文件与描述文件中的文件列表不一致时返回*IntegrityError，超过解压限制时返回*LimitError
+func copyDir(src, dst, skip string, link bool, info *ZipPackageInfo, lim *limiter) error {
+	verifier := newFileVerifier(info)
+	dirs := dirAttrs{}
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && (samePath(path, dst) || samePath(path, skip)) {
+			return filepath.SkipDir
+		}
+		if rel != "." {
+			err = lim.entry(rel)
+			if err != nil {
+				return err
+			}
+		}
+		// 与tar安装包相同，设备文件等其他类型不复制，不需要列在文件列表中
+		if rel != "." && (fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 || fi.Mode().IsRegular()) {
+			err = verifier.check(filepath.ToSlash(rel), fi.IsDir())
+			if err != nil {
+				return err
+			}
+		}
+		name, ok := platformPath(info, rel)
+		if !ok {
+			// 其他平台的文件需要列在文件列表中，但不复制，也不校验
+			if fi.IsDir() && len(verifier.files) == 0 {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, name)
+		switch {
+		case fi.IsDir():
+			dirs.set(name, fi.Mode(), fi.ModTime(), fi.ModTime())
+			return os.MkdirAll(target, 0755)
+		case fi.Mode()&os.ModeSymlink != 0:
+			dest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			err = secureLink(dst, name, dest)
+			if err != nil {
+				return err
+			}
+			err = verifier.link(filepath.ToSlash(rel), dest)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(dest, target)
+		case fi.Mode().IsRegular():
+			err = installFile(rel, path, target, fi, link, info, lim)
+			if err != nil {
+				return err
+			}
+			// 校验安装后的文件，复制过程中安装包目录中的文件可能被修改
+			return verifier.file(filepath.ToSlash(rel), target)
+		}
+		// 忽略设备文件等其他类型
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = verifier.done()
+	if err != nil {
+		return err
+	}
+	return dirs.apply(dst)
+}
+
+func samePath(a, b string) bool {
+	a, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	b, err = filepath.Abs(b)
+	return err == nil && a == b
+}
+
+// 复制的文件按读取的数据检查大小限制，硬链接不占用空间，只检查文件个数及路径深度
+// param: name为文件在安装包目录中的相对路径
+func installFile(name, src, dst string, fi os.FileInfo, link bool, info *ZipPackageInfo, lim *limiter) error {
+	os.Remove(dst)
+	if link {
+		err := os.Link(src, dst)
+		if err == nil {
+			return verifyFile(dst, info)
+		}
+		// 跨文件系统等无法创建硬链接时使用复制
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	// 未压缩，压缩比为1
+	err = copyFile(w, lim.reader(name, r, fi.Size()), dst, info)
+	w.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(dst, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// 校验硬链接的文件
+func verifyFile(filename string, info *ZipPackageInfo) error {
+	if filepath.Base(filename) != info.ExecName || info.Checksum == "" {
+		return nil
+	}
+	return checkPackage(filename, info.Checksum)
+}
+
+// 识别目录安装包
+func DetectDir(path string, head []byte) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
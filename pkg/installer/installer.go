@@ -5,6 +5,10 @@
 
 package installer
 
+import (
+	"io"
+)
+
 type PackageInfo interface {
 	// 获得安装包名称
 	GetName() string
@@ -36,6 +40,20 @@ type Package interface {
 	Equal(other Package) bool
 }
 
+// 包含描述信息（pkg.info）的安装包，描述信息包含运行所需的配置
+type InfoPackage interface {
+	// 获得安装包描述信息
+	GetPackageInfo() PackageInfo
+}
+
+// 获得安装包的描述信息，安装包未实现InfoPackage时返回nil
+func GetPackageInfo(pkg Package) PackageInfo {
+	if p, ok := pkg.(InfoPackage); ok {
+		return p.GetPackageInfo()
+	}
+	return nil
+}
+
 type Strategy interface {
 	// 生成安装包安装路径
 	GenInstallPath(dir string, pkgInfo PackageInfo) (string, error)
@@ -58,6 +76,14 @@ type Installer interface {
 	Uninstall(pkg Package, delPkg bool) error
 }
 
+// 支持从数据流安装的Installer
+type StreamInstaller interface {
+	// 从数据流安装，边读取边校验及解压，格式需要随机读取时（如zip）先写入临时文件
+	// Param: r安装包数据流，实现io.ReaderAt时可以避免写入临时文件，size数据长度，未知时为-1
+	// Return: Package安装信息， error 安装错误
+	InstallFrom(r io.Reader, size int64, strategy Strategy) (Package, error)
+}
+
 type Recorder interface {
 	// 保存安装包信息
 	Save(pkg Package) error
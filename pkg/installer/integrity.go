@@ -0,0 +1,230 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 安装包中的文件，用于校验安装包的完整性，符号链接的内容为链接目标
+type FileEntry struct {
+	// 文件在安装包中的路径，使用/分隔，如bin/hello
+	Path string `json:"path" yaml:"path"`
+	// 文件内容的SHA-256十六进制值
+	SHA256 string `json:"sha256" yaml:"sha256"`
+	// 文件长度
+	Size int64 `json:"size" yaml:"size"`
+}
+
+// 安装包的文件与描述文件中的文件列表不一致，如未列出的文件、缺失的文件、长度或校验值不匹配
+type IntegrityError struct {
+	// 安装包中的文件名
+	Entry string
+	// 原因
+	Reason string
+}
+
+func (e *IntegrityError) Error() string {
+	return "Package: entry " + e.Entry + " integrity check failed: " + e.Reason
+}
+
+// 按描述文件中的文件列表校验安装包中的每个文件，未声明文件列表时不校验
+type fileVerifier struct {
+	files map[string]*FileEntry
+	seen  map[string]bool
+}
+
+func newFileVerifier(info *ZipPackageInfo) *fileVerifier {
+	ret := &fileVerifier{
+		seen: map[string]bool{},
+	}
+	if info != nil && len(info.Files) > 0 {
+		ret.files = make(map[string]*FileEntry, len(info.Files))
+		for i := range info.Files {
+			ret.files[info.Files[i].Path] = &info.Files[i]
+		}
+	}
+	return ret
+}
+
+// 检查安装包中的文件是否在文件列表中，描述文件及目录不需要列出
+func (v *fileVerifier) check(name string, isDir bool) error {
+	if len(v.files) == 0 || isDir {
+		return nil
+	}
+	cleaned, err := cleanEntry(name)
+	if err != nil {
+		return err
+	}
+	if isRootManifest(cleaned) {
+		return nil
+	}
+	if _, ok := v.files[cleaned]; !ok {
+		return &IntegrityError{Entry: name, Reason: "not listed in manifest"}
+	}
+	v.seen[cleaned] = true
+	return nil
+}
+
+// 读取时校验文件长度，读取完毕时校验SHA-256
+func (v *fileVerifier) reader(name string, r io.Reader) io.Reader {
+	if len(v.files) == 0 {
+		return r
+	}
+	cleaned, err := cleanEntry(name)
+	if err != nil {
+		return r
+	}
+	entry, ok := v.files[cleaned]
+	if !ok {
+		return r
+	}
+	return &verifyReader{r: r, name: name, entry: entry, h: sha256.New()}
+}
+
+// 校验符号链接，符号链接的内容为链接目标
+func (v *fileVerifier) link(name, target string) error {
+	_, err := io.Copy(ioutil.Discard, v.reader(name, strings.NewReader(target)))
+	return err
+}
+
+// 校验已写入磁盘的文件，如硬链接或读取到描述文件前解压的文件
+func (v *fileVerifier) file(name, filename string) error {
+	if len(v.files) == 0 {
+		return nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(ioutil.Discard, v.reader(name, f))
+	return err
+}
+
+// 检查文件列表中的文件是否都存在于安装包中
+func (v *fileVerifier) done() error {
+	var missing []string
+	for k := range v.files {
+		if !v.seen[k] {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &IntegrityError{Entry: strings.Join(missing, ", "), Reason: "missing from package"}
+	}
+	return nil
+}
+
+type verifyReader struct {
+	r     io.Reader
+	name  string
+	entry *FileEntry
+	h     hash.Hash
+	n     int64
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.h.Write(p[:n])
+	if r.n > r.entry.Size {
+		return n, &IntegrityError{Entry: r.name, Reason: "size exceeds " + strconv.FormatInt(r.entry.Size, 10)}
+	}
+	if err == io.EOF {
+		if r.n != r.entry.Size {
+			return n, &IntegrityError{Entry: r.name, Reason: "size " + strconv.FormatInt(r.n, 10) + " not match " + strconv.FormatInt(r.entry.Size, 10)}
+		}
+		if hex.EncodeToString(r.h.Sum(nil)) != strings.ToLower(r.entry.SHA256) {
+			return n, &IntegrityError{Entry: r.name, Reason: "sha256 not match"}
+		}
+	}
+	return n, err
+}
+
+// 按文件列表校验目录中的所有文件，用于合并后的OCI镜像层
+func verifyTree(dir string, info *ZipPackageInfo) error {
+	v := newFileVerifier(info)
+	if len(v.files) == 0 {
+		return nil
+	}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		err = v.check(name, fi.IsDir())
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return v.link(name, target)
+		case fi.Mode().IsRegular():
+			return v.file(name, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return v.done()
+}
+
+// 校验描述文件中的文件列表
+func validateFiles(files []FileEntry) error {
+	paths := make(map[string]bool, len(files))
+	for i, f := range files {
+		field := "files[" + strconv.Itoa(i) + "]"
+		cleaned, err := cleanEntry(f.Path)
+		if err != nil {
+			return &ManifestError{Field: field + ".path", Err: err}
+		}
+		if f.Path == "" || cleaned != f.Path || isRootManifest(cleaned) {
+			return &ManifestError{Field: field + ".path", Err: errors.New("invalid path: " + f.Path)}
+		}
+		if paths[f.Path] {
+			return &ManifestError{Field: field + ".path", Err: errors.New("duplicate path: " + f.Path)}
+		}
+		paths[f.Path] = true
+		if _, err := hex.DecodeString(f.SHA256); err != nil || len(f.SHA256) != sha256.Size*2 {
+			return &ManifestError{Field: field + ".sha256", Err: errors.New("invalid sha256: " + f.SHA256)}
+		}
+		if f.Size < 0 {
+			return &ManifestError{Field: field + ".size", Err: errors.New("invalid size: " + strconv.FormatInt(f.Size, 10))}
+		}
+	}
+	return nil
+}
+
+// 是否为安装包根目录下的描述文件，描述文件无法包含自身的校验值
+func isRootManifest(name string) bool {
+	for _, v := range ManifestFileNames {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 描述文件的最大长度
+	maxManifestSize = 1 << 20
+	// 解压后的数据超过该长度才检查压缩比，避免小文件误判
+	ratioMinSize = 1 << 20
+)
+
+// 解压限制，在解压时按实际读取的数据检查，不信任安装包中声明的文件大小，为0则不限制
+type ExtractLimits struct {
+	// 解压后的总大小
+	MaxTotalSize int64
+	// 文件个数，包括目录及链接
+	MaxFiles int
+	// 单个文件解压后的大小
+	MaxFileSize int64
+	// 压缩比，即解压后与压缩数据的长度之比，zip按单个文件计算，tar及OCI镜像层按整个数据流计算
+	MaxRatio float64
+	// 路径深度，即路径中的层级数
+	MaxDepth int
+}
+
+// 默认的解压限制
+var DefaultExtractLimits = ExtractLimits{
+	MaxTotalSize: 4 << 30,
+	MaxFiles:     100000,
+	MaxFileSize:  2 << 30,
+	MaxRatio:     1000,
+	MaxDepth:     64,
+}
+
+// 安装包超过解压限制，如解压炸弹
+type LimitError struct {
+	// 超过限制时的文件名
+	Entry string
+	// 超过的限制
+	Limit string
+	// 限制的值
+	Max string
+}
+
+func (e *LimitError) Error() string {
+	return "Package: entry " + e.Entry + " exceeds limit " + e.Limit + ": " + e.Max
+}
+
+// 解压时统计已解压的文件个数及大小
+type limiter struct {
+	limits ExtractLimits
+	files  int
+	total  int64
+	// 流式解压时已读取的压缩数据长度
+	raw int64
+}
+
+func newLimiter(limits ExtractLimits) *limiter {
+	return &limiter{limits: limits}
+}
+
+// 统计读取的压缩数据长度，用于检查整个数据流的压缩比
+func (l *limiter) countRaw(r io.Reader) io.Reader {
+	return &rawCounter{r: r, l: l}
+}
+
+// 检查文件个数及路径深度
+func (l *limiter) entry(name string) error {
+	l.files++
+	if l.limits.MaxFiles > 0 && l.files > l.limits.MaxFiles {
+		return &LimitError{Entry: name, Limit: "maxFiles", Max: strconv.Itoa(l.limits.MaxFiles)}
+	}
+	if l.limits.MaxDepth > 0 {
+		depth := len(strings.FieldsFunc(name, func(r rune) bool {
+			return r == '/' || r == '\\'
+		}))
+		if depth > l.limits.MaxDepth {
+			return &LimitError{Entry: name, Limit: "maxDepth", Max: strconv.Itoa(l.limits.MaxDepth)}
+		}
+	}
+	return nil
+}
+
+// 读取时检查文件大小、总大小及压缩比
+// param: compressed为文件压缩后的长度，小于0时使用整个数据流的压缩比
+func (l *limiter) reader(name string, r io.Reader, compressed int64) io.Reader {
+	return &limitReader{r: r, l: l, name: name, compressed: compressed}
+}
+
+type limitReader struct {
+	r          io.Reader
+	l          *limiter
+	name       string
+	n          int64
+	compressed int64
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.l.total += int64(n)
+	limits := r.l.limits
+	if limits.MaxFileSize > 0 && r.n > limits.MaxFileSize {
+		return n, &LimitError{Entry: r.name, Limit: "maxFileSize", Max: strconv.FormatInt(limits.MaxFileSize, 10)}
+	}
+	if limits.MaxTotalSize > 0 && r.l.total > limits.MaxTotalSize {
+		return n, &LimitError{Entry: r.name, Limit: "maxTotalSize", Max: strconv.FormatInt(limits.MaxTotalSize, 10)}
+	}
+	if limits.MaxRatio > 0 {
+		size, compressed := r.n, r.compressed
+		if compressed < 0 {
+			size, compressed = r.l.total, r.l.raw
+		}
+		if size > ratioMinSize && float64(size) > limits.MaxRatio*float64(compressed) {
+			return n, &LimitError{Entry: r.name, Limit: "maxRatio", Max: strconv.FormatFloat(limits.MaxRatio, 'f', -1, 64)}
+		}
+	}
+	return n, err
+}
+
+type rawCounter struct {
+	r io.Reader
+	l *limiter
+}
+
+func (r *rawCounter) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.l.raw += int64(n)
+	return n, err
+}
+
+// 读取描述文件，超过最大长度时返回*LimitError
+func readManifestData(name string, r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(io.LimitReader(r, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxManifestSize {
+		return nil, &LimitError{Entry: name, Limit: "manifestSize", Max: strconv.Itoa(maxManifestSize)}
+	}
+	return buf.Bytes(), nil
+}
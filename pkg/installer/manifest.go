@@ -0,0 +1,258 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 安装包描述文件格式
+const (
+	ManifestJSON = "json"
+	ManifestYAML = "yaml"
+	ManifestTOML = "toml"
+)
+
+// 安装包描述文件名，按顺序查找，pkg.info根据内容识别格式
+var ManifestFileNames = []string{ZIP_INFO_FILENAME, "pkg.yaml", "pkg.yml", "pkg.toml"}
+
+var tomlKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-."']+\s*=`)
+
+// 安装包描述文件错误，Field为出错的字段路径，如restart.backoff，为空则表示文件格式错误
+type ManifestError struct {
+	// 描述文件名
+	File string
+	// 字段路径
+	Field string
+	Err   error
+}
+
+func (e *ManifestError) Error() string {
+	if e.Field == "" {
+		return "Manifest: " + e.File + " parse error: " + e.Err.Error()
+	}
+	return "Manifest: " + e.File + " field: " + e.Field + " invalid: " + e.Err.Error()
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// 是否为安装包描述文件
+func IsManifestFile(name string) bool {
+	base := filepath.Base(name)
+	for _, v := range ManifestFileNames {
+		if base == v {
+			return true
+		}
+	}
+	return false
+}
+
+// 根据文件名识别描述文件格式，pkg.info根据内容识别
+func ManifestFormat(name string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return ManifestJSON
+	case ".yaml", ".yml":
+		return ManifestYAML
+	case ".toml":
+		return ManifestTOML
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '{' {
+			return ManifestJSON
+		}
+		if line[0] == '[' || tomlKeyRegexp.MatchString(line) {
+			return ManifestTOML
+		}
+		return ManifestYAML
+	}
+	return ManifestJSON
+}
+
+// 解析安装包描述文件，支持json、yaml、toml格式
+// 低版本协议的描述文件将升级为当前版本，高于当前版本时返回包含*ProtocolError的*ManifestError
+// Param: name描述文件名，用于识别格式及错误信息，data文件内容
+func ParseManifest(name string, data []byte) (*ZipPackageInfo, error) {
+	file := filepath.Base(name)
+	m := map[string]interface{}{}
+	var err error
+	switch ManifestFormat(name, data) {
+	case ManifestYAML:
+		err = yaml.Unmarshal(data, &m)
+	case ManifestTOML:
+		_, err = toml.Decode(string(data), &m)
+	default:
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, &ManifestError{File: file, Err: err}
+	}
+	err = migrateManifest(m)
+	if err != nil {
+		return nil, &ManifestError{File: file, Field: "protocolVersion", Err: err}
+	}
+
+	ret := &ZipPackageInfo{}
+	err = decodeFields(reflect.ValueOf(ret).Elem(), m, "")
+	if err != nil {
+		if e, ok := err.(*ManifestError); ok {
+			e.File = file
+		}
+		return nil, err
+	}
+	err = validateManifest(ret)
+	if err != nil {
+		err.(*ManifestError).File = file
+		return nil, err
+	}
+	err = selectPlatform(ret)
+	if err != nil {
+		return nil, &ManifestError{File: file, Field: "platforms", Err: err}
+	}
+	return ret, nil
+}
+
+// 按json标签逐个字段解码，以便在错误中指出出错的字段
+func decodeFields(v reflect.Value, m map[string]interface{}, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		value, ok := lookupKey(m, key)
+		if !ok || value == nil {
+			continue
+		}
+		fv := v.Field(i)
+		if sub, ok := value.(map[string]interface{}); ok && isStructPtr(sf.Type) {
+			fv.Set(reflect.New(sf.Type.Elem()))
+			err := decodeFields(fv.Elem(), sub, prefix+key+".")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		d, err := json.Marshal(stringify(value, sf.Type))
+		if err == nil {
+			err = json.Unmarshal(d, fv.Addr().Interface())
+		}
+		if err != nil {
+			return &ManifestError{Field: prefix + key, Err: err}
+		}
+	}
+	return nil
+}
+
+// 与encoding/json一致，字段名优先精确匹配，其次忽略大小写匹配
+func lookupKey(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func isStructPtr(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && !t.Implements(jsonUnmarshalerType)
+}
+
+// yaml、toml中未加引号的数字及布尔值在目标为字符串时转换为字符串，如env中的PORT: 8080
+func stringify(value interface{}, t reflect.Type) interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		switch value.(type) {
+		case int, int64, uint64, float64, bool:
+			return fmt.Sprint(value)
+		}
+	case reflect.Map:
+		if m, ok := value.(map[string]interface{}); ok {
+			ret := make(map[string]interface{}, len(m))
+			for k, v := range m {
+				ret[k] = stringify(v, t.Elem())
+			}
+			return ret
+		}
+	case reflect.Slice:
+		if s, ok := value.([]interface{}); ok {
+			ret := make([]interface{}, len(s))
+			for i, v := range s {
+				ret[i] = stringify(v, t.Elem())
+			}
+			return ret
+		}
+	}
+	return value
+}
+
+func validateManifest(info *ZipPackageInfo) error {
+	if info.Name == "" {
+		return &ManifestError{Field: "name", Err: errors.New("name is required")}
+	}
+	if strings.ContainsAny(info.Name, `/\`) || info.Name == "." || info.Name == ".." {
+		return &ManifestError{Field: "name", Err: errors.New("invalid name: " + info.Name)}
+	}
+	if info.Checksum != "" {
+		if _, _, err := parseChecksum(info.Checksum); err != nil {
+			return &ManifestError{Field: "checksum", Err: err}
+		}
+	}
+	if info.Restart != nil {
+		switch info.Restart.Policy {
+		case "", RestartNever, RestartOnFailure, RestartAlways:
+		default:
+			return &ManifestError{Field: "restart.policy", Err: errors.New("unknown policy: " + info.Restart.Policy)}
+		}
+	}
+	switch info.Type {
+	case PackageTypeApp, PackageTypeGoPlugin, PackageTypeRPCPlugin:
+	default:
+		return &ManifestError{Field: "type", Err: errors.New("unknown type: " + info.Type)}
+	}
+	if info.Type == PackageTypeGoPlugin && info.ExecName == "" {
+		return &ManifestError{Field: "execName", Err: errors.New("execName is required for plugin")}
+	}
+	for k, v := range info.Platforms {
+		if k == "" || strings.ContainsAny(k, `/\`) || k == "." || k == ".." {
+			return &ManifestError{Field: "platforms", Err: errors.New("invalid platform: " + k)}
+		}
+		if v != nil && v.Checksum != "" {
+			if _, _, err := parseChecksum(v.Checksum); err != nil {
+				return &ManifestError{Field: "platforms." + k + ".checksum", Err: err}
+			}
+		}
+	}
+	if err := validateFiles(info.Files); err != nil {
+		return err
+	}
+	if info.Schedule != nil && info.Schedule.Cron == "" {
+		return &ManifestError{Field: "schedule.cron", Err: errors.New("cron is required")}
+	}
+	return nil
+}
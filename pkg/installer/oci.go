@@ -0,0 +1,462 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	io2 "github.com/xfali/goutils/io"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// 保存描述文件内容的注解，可以声明在镜像manifest的注解或镜像配置的Labels中
+	// 未声明时读取镜像层根目录下的描述文件
+	OCIInfoAnnotation = "com.github.xfali.magnet.pkg.info"
+
+	ociLayoutFile = "oci-layout"
+	ociIndexFile  = "index.json"
+	ociWhiteout   = ".wh."
+	ociOpaque     = ".wh..wh..opq"
+)
+
+var digestHexRegexp = regexp.MustCompile(`^[a-f0-9]+$`)
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+// image index及image manifest，Manifests不为空时为image index
+type ociManifest struct {
+	MediaType   string            `json:"mediaType"`
+	Manifests   []ociDescriptor   `json:"manifests,omitempty"`
+	Config      ociDescriptor     `json:"config"`
+	Layers      []ociDescriptor   `json:"layers,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociImageConfig struct {
+	Config struct {
+		Labels map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+}
+
+// OCI镜像布局安装包，安装包为包含oci-layout、index.json及blobs的目录
+// 多平台镜像选择当前平台的manifest，按顺序应用镜像层（包括删除标记）到安装路径，安装包目录在卸载时不会被删除
+type OCIInstaller struct {
+	baseInstaller
+}
+
+func CreateOCIInstaller(installDir string, opts ...Opt) (*OCIInstaller, error) {
+	base, err := newBaseInstaller(installDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &OCIInstaller{base}, nil
+}
+
+func (inst *OCIInstaller) ReadInfo(path string) (PackageInfo, error) {
+	_, m, err := resolveImage(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := annotationInfo(path, m)
+	if err != nil || info != nil {
+		return info, err
+	}
+	return layerInfo(path, m, newLimiter(inst.conf.limits))
+}
+
+func (inst *OCIInstaller) Install(path string, strategy Strategy) (Package, error) {
+	pkg, err := inst.install(path, strategy)
+	if pkg == nil {
+		return nil, err
+	}
+	return pkg, err
+}
+
+func (inst *OCIInstaller) install(path string, strategy Strategy) (*ZipPackage, error) {
+	desc, m, err := resolveImage(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := annotationInfo(path, m)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &ZipPackage{KeepPkg: true, Digest: desc.Digest}
+	return inst.installStaged(pkg, path, strategy, func(staging string) (*ZipPackageInfo, error) {
+		return applyImage(path, m, staging, info, newLimiter(inst.conf.limits))
+	})
+}
+
+// 按顺序应用镜像层到目录，info为nil时读取目录中的描述文件
+// 解压限制按所有镜像层计算
+func applyImage(layout string, m *ociManifest, dir string, info *ZipPackageInfo, lim *limiter) (*ZipPackageInfo, error) {
+	dirs := dirAttrs{}
+	for _, layer := range m.Layers {
+		err := applyLayer(layout, layer, dir, dirs, lim)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := dirs.apply(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		info, err = getDirPackageInfo(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 镜像层的摘要已校验，按文件列表校验合并后的文件
+	err = verifyTree(dir, info)
+	if err != nil {
+		return nil, err
+	}
+	err = applyPlatform(dir, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, verifyDir(dir, info)
+}
+
+func (inst *OCIInstaller) Uninstall(pkg Package, del bool) error {
+	return pkg.Uninstall(del)
+}
+
+// 读取index.json并选择当前平台的image manifest
+func resolveImage(layout string) (ociDescriptor, *ociManifest, error) {
+	d, err := ioutil.ReadFile(filepath.Join(layout, ociIndexFile))
+	if err != nil {
+		return ociDescriptor{}, nil, err
+	}
+	index := &ociManifest{}
+	err = json.Unmarshal(d, index)
+	if err != nil {
+		return ociDescriptor{}, nil, errors.New("OCI: " + layout + " invalid index.json: " + err.Error())
+	}
+	return selectManifest(layout, index.Manifests)
+}
+
+func selectManifest(layout string, descs []ociDescriptor) (ociDescriptor, *ociManifest, error) {
+	var supported []string
+	for _, desc := range descs {
+		if desc.Platform != nil {
+			p := desc.Platform.OS + "-" + desc.Platform.Architecture
+			if p != HostPlatform() {
+				supported = append(supported, p)
+				continue
+			}
+		}
+		m := &ociManifest{}
+		err := readBlobJSON(layout, desc.Digest, m)
+		if err != nil {
+			return desc, nil, err
+		}
+		// 嵌套的image index
+		if len(m.Manifests) > 0 {
+			return selectManifest(layout, m.Manifests)
+		}
+		return desc, m, nil
+	}
+	if len(supported) > 0 {
+		sort.Strings(supported)
+		return ociDescriptor{}, nil, &PlatformError{Platform: HostPlatform(), Supported: supported}
+	}
+	return ociDescriptor{}, nil, errors.New("OCI: " + layout + " image manifest not found")
+}
+
+// 从manifest注解或镜像配置的Labels中读取描述文件，均未声明时返回nil
+func annotationInfo(layout string, m *ociManifest) (*ZipPackageInfo, error) {
+	if v, ok := m.Annotations[OCIInfoAnnotation]; ok {
+		return ParseManifest(ZIP_INFO_FILENAME, []byte(v))
+	}
+	if m.Config.Digest == "" {
+		return nil, nil
+	}
+	conf := &ociImageConfig{}
+	err := readBlobJSON(layout, m.Config.Digest, conf)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := conf.Config.Labels[OCIInfoAnnotation]; ok {
+		return ParseManifest(ZIP_INFO_FILENAME, []byte(v))
+	}
+	return nil, nil
+}
+
+// 读取镜像层根目录下的描述文件，上层的描述文件覆盖下层
+func layerInfo(layout string, m *ociManifest, lim *limiter) (*ZipPackageInfo, error) {
+	var ret *ZipPackageInfo
+	for _, layer := range m.Layers {
+		err := walkLayer(layout, layer.Digest, lim, func(name string, hdr *tar.Header, r io.Reader) error {
+			if path.Dir(name) != "." {
+				return nil
+			}
+			base := path.Base(name)
+			if strings.HasPrefix(base, ociWhiteout) && IsManifestFile(base[len(ociWhiteout):]) {
+				ret = nil
+				return nil
+			}
+			if hdr.Typeflag != tar.TypeReg || !IsManifestFile(base) {
+				return nil
+			}
+			d, err := readManifestData(name, r)
+			if err != nil {
+				return err
+			}
+			ret, err = ParseManifest(base, d)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ret == nil {
+		return nil, errors.New("pkg.info not found")
+	}
+	return ret, nil
+}
+
+// 应用镜像层，dirs记录目录的权限及修改时间，在所有镜像层应用后设置
+func applyLayer(layout string, layer ociDescriptor, dir string, dirs dirAttrs, lim *limiter) error {
+	// 本层添加的文件，不透明目录只删除下层的文件
+	added := map[string]bool{}
+	var opaque []string
+	err := walkLayer(layout, layer.Digest, lim, func(name string, hdr *tar.Header, r io.Reader) error {
+		base, parent := path.Base(name), path.Dir(name)
+		if base == ociOpaque {
+			opaque = append(opaque, parent)
+			return nil
+		}
+		if strings.HasPrefix(base, ociWhiteout) {
+			name = path.Join(parent, base[len(ociWhiteout):])
+			target, err := securePath(dir, name)
+			if err != nil {
+				return err
+			}
+			dirs.remove(name)
+			return os.RemoveAll(target)
+		}
+		added[name] = true
+		target, err := securePath(dir, name)
+		if err != nil {
+			return err
+		}
+		// 替换下层的文件，下层的文件可能是硬链接，不能直接覆盖写入
+		if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			err = os.RemoveAll(target)
+			if err != nil {
+				return err
+			}
+		}
+		if hdr.Typeflag == tar.TypeDir && name != "." {
+			dirs.set(name, hdr.FileInfo().Mode(), accessTime(hdr), hdr.ModTime)
+		}
+		// 镜像层中的路径及硬链接均相对于镜像根目录
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = strings.TrimPrefix(hdr.Linkname, "/")
+		}
+		return extractTarEntry(dir, hdr, r, nil)
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range opaque {
+		err := removeLower(dir, name, added)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除不透明目录中下层的文件
+func removeLower(dir, name string, added map[string]bool) error {
+	p, err := securePath(dir, name)
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range files {
+		child := path.Join(name, fi.Name())
+		if !added[child] {
+			err = os.RemoveAll(filepath.Join(dir, child))
+		} else if fi.IsDir() {
+			err = removeLower(dir, child, added)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 校验目录中的可执行文件
+func verifyDir(dir string, info *ZipPackageInfo) error {
+	if info.Checksum == "" {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		return verifyFile(path, info)
+	})
+}
+
+// 遍历镜像层中的文件，name为清理后的相对路径，读取完成后校验镜像层的摘要
+// 超过解压限制时返回*LimitError
+func walkLayer(layout, digest string, lim *limiter, f func(name string, hdr *tar.Header, r io.Reader) error) error {
+	blob, err := openBlob(layout, digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	dr, err := decompress(lim.countRaw(blob))
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// 镜像层中的路径可以以/开头，使用..逃逸时返回*UnsafePathError
+		name, err := cleanEntry(strings.TrimLeft(hdr.Name, "/"))
+		if err != nil {
+			return err
+		}
+		err = lim.entry(name)
+		if err != nil {
+			return err
+		}
+		err = f(name, hdr, lim.reader(name, tr, -1))
+		if err != nil {
+			return err
+		}
+	}
+	// 读取剩余数据以校验摘要
+	_, err = io.Copy(ioutil.Discard, blob)
+	return err
+}
+
+func readBlobJSON(layout, digest string, v interface{}) error {
+	blob, err := openBlob(layout, digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	d, err := readManifestData(digest, blob)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(d, v)
+	if err != nil {
+		return errors.New("OCI: blob " + digest + " invalid: " + err.Error())
+	}
+	return nil
+}
+
+// 读取时计算摘要，读取完成时摘要不一致返回错误
+type blobReader struct {
+	f      *os.File
+	h      hash.Hash
+	sum    string
+	digest string
+}
+
+// 解析OCI摘要，格式为"算法:十六进制值"，只支持sha256及sha512
+// Return: 算法、哈希及期望的十六进制值
+func parseDigest(digest string) (string, hash.Hash, string, error) {
+	i := strings.IndexByte(digest, ':')
+	if i < 0 {
+		return "", nil, "", errors.New("OCI: invalid digest: " + digest)
+	}
+	algo, sum := digest[:i], digest[i+1:]
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", nil, "", errors.New("OCI: unsupported digest algorithm: " + digest)
+	}
+	if len(sum) != hex.EncodedLen(h.Size()) || !digestHexRegexp.MatchString(sum) {
+		return "", nil, "", errors.New("OCI: invalid digest: " + digest)
+	}
+	return algo, h, sum, nil
+}
+
+func openBlob(layout, digest string) (*blobReader, error) {
+	algo, h, sum, err := parseDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(layout, "blobs", algo, sum))
+	if err != nil {
+		return nil, err
+	}
+	return &blobReader{f: f, h: h, sum: sum, digest: digest}, nil
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.h.Sum(nil)) != r.sum {
+		return n, errors.New("OCI: blob " + r.digest + " digest not match ")
+	}
+	return n, err
+}
+
+func (r *blobReader) Close() error {
+	return r.f.Close()
+}
+
+// 识别OCI镜像布局，目录中包含oci-layout及index.json
+func DetectOCI(path string, head []byte) bool {
+	if head != nil {
+		return false
+	}
+	return io2.IsPathExists(filepath.Join(path, ociLayoutFile)) && io2.IsPathExists(filepath.Join(path, ociIndexFile))
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// 声明了运行用户及用户组的安装包信息
+type OwnerInfo interface {
+	// 获得安装包声明的用户，为空则使用当前用户
+	GetUser() string
+
+	// 获得安装包声明的用户组，为空则使用用户的主组
+	GetGroup() string
+}
+
+// 用户策略，限制安装包可以声明的用户及用户组
+type UserPolicy interface {
+	// 检查安装包是否可以使用指定的用户及用户组，不允许时返回error
+	// 用户或用户组为空表示未声明
+	Check(user, group string) error
+}
+
+type AllowUserPolicy struct {
+	users  map[string]bool
+	groups map[string]bool
+}
+
+// 创建白名单用户策略，只允许使用users中的用户及groups中的用户组
+func NewUserPolicy(users []string, groups []string) *AllowUserPolicy {
+	ret := &AllowUserPolicy{
+		users:  map[string]bool{},
+		groups: map[string]bool{},
+	}
+	for _, v := range users {
+		ret.users[v] = true
+	}
+	for _, v := range groups {
+		ret.groups[v] = true
+	}
+	return ret
+}
+
+func (p *AllowUserPolicy) Check(user, group string) error {
+	if user != "" && !p.users[user] {
+		return errors.New("User: " + user + " is not allowed ")
+	}
+	if group != "" && !p.groups[group] {
+		return errors.New("Group: " + group + " is not allowed ")
+	}
+	return nil
+}
+
+// 使用用户策略检查安装包信息，policy为nil时不做限制
+func CheckUser(policy UserPolicy, info PackageInfo) error {
+	if policy == nil {
+		return nil
+	}
+	if o, ok := info.(OwnerInfo); ok {
+		return policy.Check(o.GetUser(), o.GetGroup())
+	}
+	return nil
+}
+
+// 查找用户及用户组的id，支持名称或者数字id
+// Return: 未声明的用户或用户组返回-1，仅声明用户时返回用户的主组
+func LookupOwner(userName, groupName string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			u, err = user.LookupId(userName)
+			if err != nil {
+				return -1, -1, err
+			}
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return -1, -1, err
+		}
+		if groupName == "" {
+			gid, err = strconv.Atoi(u.Gid)
+			if err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+			if err != nil {
+				return -1, -1, err
+			}
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+	return uid, gid, nil
+}
+
+// 修改目录下所有文件的所有者，uid、gid为-1时不修改
+func chownAll(dir string, uid, gid int) error {
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// 平台相关的安装内容，安装包中与平台同名的目录（如linux-amd64/）只在对应平台安装，安装时目录中的文件放置在安装目录下
+type Platform struct {
+	// 该平台的可执行文件名，为空则使用execName
+	ExecName string `json:"execName,omitempty" yaml:"execName,omitempty"`
+	// 该平台可执行文件的校验值，为空则使用checksum
+	Checksum string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+}
+
+// 安装包声明了平台但不包含当前平台
+type PlatformError struct {
+	// 当前平台
+	Platform string
+	// 安装包支持的平台
+	Supported []string
+}
+
+func (e *PlatformError) Error() string {
+	return "platform " + e.Platform + " not supported, supported platforms: " + strings.Join(e.Supported, ", ")
+}
+
+// 获得当前平台，格式为GOOS-GOARCH，如linux-amd64
+func HostPlatform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// 根据当前平台设置可执行文件名及校验值
+func selectPlatform(info *ZipPackageInfo) error {
+	if len(info.Platforms) == 0 {
+		return nil
+	}
+	p, ok := info.Platforms[HostPlatform()]
+	if !ok {
+		supported := make([]string, 0, len(info.Platforms))
+		for k := range info.Platforms {
+			supported = append(supported, k)
+		}
+		sort.Strings(supported)
+		return &PlatformError{Platform: HostPlatform(), Supported: supported}
+	}
+	if p != nil && p.ExecName != "" {
+		info.ExecName = p.ExecName
+	}
+	if p != nil && p.Checksum != "" {
+		info.Checksum = p.Checksum
+	}
+	return nil
+}
+
+// 获得安装包中的文件在安装目录中的相对路径
+// Return: 相对路径，不属于当前平台的文件返回false
+func platformPath(info *ZipPackageInfo, name string) (string, bool) {
+	if len(info.Platforms) == 0 {
+		return name, true
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	first := name
+	rest := "."
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		first, rest = name[:i], name[i+1:]
+	}
+	if _, ok := info.Platforms[first]; !ok {
+		return name, true
+	}
+	if first != HostPlatform() {
+		return "", false
+	}
+	return rest, true
+}
+
+// 删除目录中其他平台的文件，并将当前平台的文件移动到目录下
+func applyPlatform(dir string, info *ZipPackageInfo) error {
+	for k := range info.Platforms {
+		p := filepath.Join(dir, k)
+		if k == HostPlatform() {
+			if _, err := os.Stat(p); err != nil {
+				continue
+			}
+			tmp := filepath.Join(dir, ".platform-"+k)
+			err := os.Rename(p, tmp)
+			if err != nil {
+				return err
+			}
+			err = moveDir(tmp, dir)
+			if err != nil {
+				return err
+			}
+			p = tmp
+		}
+		err := os.RemoveAll(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// 描述文件协议版本
+// 1: 初始版本，checksum为可执行文件的md5值
+// 2: checksum格式为"算法:十六进制值"，支持md5、sha256、sha512
+const (
+	// 支持的最低协议版本，未声明协议版本的描述文件视为该版本
+	MinProtocolVersion = 1
+	// 当前协议版本，读取时低版本的描述文件将升级为该版本
+	CurrentProtocolVersion = 2
+)
+
+// 描述文件升级函数，将version版本的描述文件转换为version+1版本
+// 在解码为ZipPackageInfo前对原始内容进行转换，以便后续版本修改字段名称或结构
+type migration func(m map[string]interface{}) error
+
+var migrations = map[int]migration{
+	1: migrateV1,
+}
+
+// 描述文件的协议版本不受支持，如使用更新版本的打包工具生成的安装包
+type ProtocolError struct {
+	// 描述文件声明的协议版本
+	Version int
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("protocol version %d not supported, supported versions: %d-%d",
+		e.Version, MinProtocolVersion, CurrentProtocolVersion)
+}
+
+// 将描述文件升级为当前协议版本
+func migrateManifest(m map[string]interface{}) error {
+	version, err := manifestVersion(m)
+	if err != nil {
+		return err
+	}
+	// 0为未声明
+	if version == 0 {
+		version = MinProtocolVersion
+	}
+	if version < MinProtocolVersion || version > CurrentProtocolVersion {
+		return &ProtocolError{Version: version}
+	}
+	for v := version; v < CurrentProtocolVersion; v++ {
+		err := migrations[v](m)
+		if err != nil {
+			return err
+		}
+		m["protocolVersion"] = v + 1
+	}
+	return nil
+}
+
+func manifestVersion(m map[string]interface{}) (int, error) {
+	v, ok := lookupKey(m, "protocolVersion")
+	if !ok || v == nil {
+		return MinProtocolVersion, nil
+	}
+	switch t := v.(type) {
+	case int:
+		return t, nil
+	case int64:
+		return int(t), nil
+	case uint64:
+		return int(t), nil
+	case float64:
+		if t == float64(int(t)) {
+			return int(t), nil
+		}
+	case string:
+		return strconv.Atoi(t)
+	}
+	return 0, errors.New("invalid protocol version: " + fmt.Sprint(v))
+}
+
+// 版本1的checksum为md5值，转换为"md5:值"
+func migrateV1(m map[string]interface{}) error {
+	for k, v := range m {
+		if !strings.EqualFold(k, "checksum") {
+			continue
+		}
+		if s, ok := v.(string); ok && s != "" && !strings.Contains(s, ":") {
+			m[k] = "md5:" + s
+		}
+	}
+	return nil
+}
+
+// 解析checksum，格式为"算法:十六进制值"，未声明算法时为md5
+// Return: 哈希算法及期望的十六进制值
+func parseChecksum(checksum string) (hash.Hash, string, error) {
+	algo, sum := "md5", checksum
+	if i := strings.IndexByte(checksum, ':'); i >= 0 {
+		algo, sum = strings.ToLower(checksum[:i]), checksum[i+1:]
+	}
+	switch algo {
+	case "md5":
+		return md5.New(), strings.ToLower(sum), nil
+	case "sha256":
+		return sha256.New(), strings.ToLower(sum), nil
+	case "sha512":
+		return sha512.New(), strings.ToLower(sum), nil
+	}
+	return nil, "", errors.New("unsupported checksum algorithm: " + algo)
+}
@@ -36,6 +36,7 @@ func CreateRecorder(path string) (*ZipRecorder, error) {
 			return nil, err
 		}
 		for k, v := range tmp {
+			v.loadPackageInfo()
 			ret.pkgs[k] = v
 		}
 	}
@@ -111,6 +112,7 @@ func CreateJsonRecorder(path string) (*JsonRecorder, error) {
 		for k, v := range tmp {
 			pkgs := make([]Package, len(v))
 			for i := range v {
+				v[i].loadPackageInfo()
 				pkgs[i] = v[i]
 			}
 			ret.pkgs[k] = pkgs
@@ -0,0 +1,212 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// 安装包格式
+const (
+	FormatZip = "zip"
+	FormatTar = "tar"
+	FormatDir = "dir"
+	FormatOCI = "oci"
+)
+
+const (
+	// 识别格式时读取的文件头长度，包含tar头中的ustar标识
+	sniffLen = 512
+)
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	ustarMagic    = []byte("ustar")
+)
+
+// 格式识别函数
+// Param: path安装包路径，head安装包的文件头，path为目录时head为nil
+// Return: 是否为该格式的安装包
+type Detector func(path string, head []byte) bool
+
+type format struct {
+	name      string
+	detect    Detector
+	installer Installer
+}
+
+// 安装包格式注册表，根据文件头识别安装包格式，并将ReadInfo、Install分发给对应格式的Installer
+// 所有格式的Installer需返回*ZipPackage，以便使用同一个Recorder记录
+type Registry struct {
+	formats []format
+	lock    sync.RWMutex
+}
+
+// 创建空的注册表
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// 创建注册了zip、tar（包括gzip、xz、zstd压缩）、OCI镜像布局及目录格式的注册表
+func CreateRegistry(installDir string, opts ...Opt) (*Registry, error) {
+	zipInst, err := CreateInstaller(installDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tarInst, err := CreateTarInstaller(installDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ociInst, err := CreateOCIInstaller(installDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	dirInst, err := CreateDirInstaller(installDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ret := NewRegistry()
+	ret.Register(FormatZip, DetectZip, zipInst)
+	ret.Register(FormatTar, DetectTar, tarInst)
+	// OCI镜像布局也是目录，需在目录格式之前识别
+	ret.Register(FormatOCI, DetectOCI, ociInst)
+	ret.Register(FormatDir, DetectDir, dirInst)
+	return ret, nil
+}
+
+// 注册安装包格式，按注册顺序识别，同名格式将被替换
+func (r *Registry) Register(name string, detect Detector, inst Installer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.formats {
+		if r.formats[i].name == name {
+			r.formats[i].detect = detect
+			r.formats[i].installer = inst
+			return
+		}
+	}
+	r.formats = append(r.formats, format{
+		name:      name,
+		detect:    detect,
+		installer: inst,
+	})
+}
+
+// 识别安装包格式
+// Return: 格式名称及对应的Installer，无法识别时返回错误
+func (r *Registry) Detect(path string) (string, Installer, error) {
+	head, err := readHead(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.detect(path, head)
+}
+
+func (r *Registry) detect(path string, head []byte) (string, Installer, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, f := range r.formats {
+		if f.detect(path, head) {
+			return f.name, f.installer, nil
+		}
+	}
+	if path == "" {
+		return "", nil, errors.New("Package format not supported ")
+	}
+	return "", nil, errors.New("Package: " + path + " format not supported ")
+}
+
+func (r *Registry) ReadInfo(path string) (PackageInfo, error) {
+	_, inst, err := r.Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	return inst.ReadInfo(path)
+}
+
+func (r *Registry) Install(path string, strategy Strategy) (Package, error) {
+	_, inst, err := r.Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	return inst.Install(path, strategy)
+}
+
+// 根据数据流的文件头识别格式，r实现io.ReaderAt时直接传递给对应格式的Installer
+func (r *Registry) InstallFrom(rd io.Reader, size int64, strategy Strategy) (Package, error) {
+	var head []byte
+	if ra, ok := rd.(io.ReaderAt); ok {
+		head = make([]byte, sniffLen)
+		n, err := ra.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		head = head[:n]
+	} else {
+		br := bufio.NewReaderSize(rd, sniffLen)
+		var err error
+		head, err = br.Peek(sniffLen)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		rd = br
+	}
+	format, inst, err := r.detect("", head)
+	if err != nil {
+		return nil, err
+	}
+	si, ok := inst.(StreamInstaller)
+	if !ok {
+		return nil, errors.New("Package format " + format + " does not support install from stream ")
+	}
+	return si.InstallFrom(rd, size, strategy)
+}
+
+func (r *Registry) Uninstall(pkg Package, del bool) error {
+	return pkg.Uninstall(del)
+}
+
+func readHead(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// 识别zip安装包
+func DetectZip(path string, head []byte) bool {
+	return bytes.HasPrefix(head, zipMagic) || bytes.HasPrefix(head, zipEmptyMagic)
+}
+
+// 识别tar安装包，包括gzip、xz、zstd压缩的tar包
+func DetectTar(path string, head []byte) bool {
+	if bytes.HasPrefix(head, gzipMagic) || bytes.HasPrefix(head, xzMagic) || bytes.HasPrefix(head, zstdMagic) {
+		return true
+	}
+	return len(head) >= 262 && bytes.Equal(head[257:262], ustarMagic)
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 不重启
+	RestartNever = "never"
+	// 非0退出码退出时重启
+	RestartOnFailure = "on-failure"
+	// 进程退出即重启
+	RestartAlways = "always"
+)
+
+// 时间间隔，json中使用time.ParseDuration格式的字符串，如"1s"、"500ms"，也可以使用纳秒数
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case float64:
+		*d = Duration(t)
+		return nil
+	case string:
+		ret, err := time.ParseDuration(t)
+		if err != nil {
+			return err
+		}
+		*d = Duration(ret)
+		return nil
+	}
+	return errors.New("Invalid duration: " + string(data))
+}
+
+// 应用重启策略
+type RestartPolicy struct {
+	// 重启策略：never、on-failure、always，默认为never
+	Policy string `json:"policy" yaml:"policy"`
+	// 最大连续重启次数，0为不限制
+	MaxRetries int `json:"maxRetries" yaml:"maxRetries"`
+	// 首次重启等待时间，之后每次重启等待时间翻倍
+	Backoff Duration `json:"backoff" yaml:"backoff"`
+	// 最大重启等待时间，进程运行超过该时间视为运行稳定，将重置重启次数及等待时间
+	MaxBackoff Duration `json:"maxBackoff" yaml:"maxBackoff"`
+}
+
+// 应用健康检查，Exec、TCPPort、HTTPGet任选其一
+type HealthCheck struct {
+	// 执行命令，退出码为0视为健康，支持与ExecCmd相同的变量
+	Exec string `json:"exec,omitempty" yaml:"exec,omitempty"`
+	// 连接本机端口，连接成功视为健康
+	TCPPort int `json:"tcpPort,omitempty" yaml:"tcpPort,omitempty"`
+	// 对本机地址发送HTTP GET请求，返回2xx、3xx视为健康
+	HTTPGet string `json:"httpGet,omitempty" yaml:"httpGet,omitempty"`
+
+	// 检查间隔
+	Interval Duration `json:"interval" yaml:"interval"`
+	// 单次检查超时时间
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+	// 连续失败次数达到该值视为不健康
+	Threshold int `json:"threshold" yaml:"threshold"`
+	// 启动宽限期，应用启动后该时间内的检查失败不计入连续失败次数
+	StartPeriod Duration `json:"startPeriod,omitempty" yaml:"startPeriod,omitempty"`
+	// 等待应用健康的总超时时间，包括启动宽限期
+	WaitTimeout Duration `json:"waitTimeout,omitempty" yaml:"waitTimeout,omitempty"`
+}
+
+// 字节数，json中可以使用数字或者带单位的字符串，如"512K"、"256M"、"1G"（按1024进位）
+type ByteSize int64
+
+func (s ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(s))
+}
+
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case float64:
+		*s = ByteSize(t)
+		return nil
+	case string:
+		ret, err := ParseByteSize(t)
+		if err != nil {
+			return err
+		}
+		*s = ret
+		return nil
+	}
+	return errors.New("Invalid size: " + string(data))
+}
+
+// 解析带单位的字节数，支持K、M、G、T单位（按1024进位），单位后的B/iB可省略
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	unit := int64(1)
+	if str != "" {
+		switch str[len(str)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		case 'T':
+			unit = 1 << 40
+		}
+		if unit > 1 {
+			str = str[:len(str)-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("Invalid size: " + s)
+	}
+	return ByteSize(v * float64(unit)), nil
+}
+
+// 应用资源限制，仅在Linux下生效
+// 存在可写的cgroup v2时使用cgroup限制CPU、内存及进程数，否则使用setrlimit限制内存（RLIMIT_AS）
+type ResourceLimits struct {
+	// CPU核数，如0.5，仅cgroup v2可用
+	CPU float64 `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+	// 内存上限
+	Memory ByteSize `json:"memory,omitempty" yaml:"memory,omitempty"`
+	// 最大进程（线程）数，仅cgroup v2可用，不可用时启动失败
+	Pids int64 `json:"pids,omitempty" yaml:"pids,omitempty"`
+	// 最大打开文件数，使用RLIMIT_NOFILE限制
+	NoFile uint64 `json:"noFile,omitempty" yaml:"noFile,omitempty"`
+}
+
+// 定时任务，声明后应用按计划运行而不是作为常驻进程运行
+type Schedule struct {
+	// cron表达式，格式为：秒 分 时 日 月 周，秒可省略，也支持@every 1h、@daily等描述符
+	Cron string `json:"cron" yaml:"cron"`
+	// 时区，如Asia/Shanghai，默认为本地时区
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+}
+
+// 沙箱，仅在Linux下生效，使用私有的mount及pid命名空间运行应用，安装目录以只读方式挂载
+// 宿主非root用户运行时需要系统支持非特权用户命名空间，此时应用在命名空间内以root（映射为宿主用户）运行
+// 应用在pid命名空间中为1号进程，未设置处理函数的停止信号将被忽略，超过stopTimeout后强制结束
+type Sandbox struct {
+	// 使用私有的网络命名空间，应用将无法访问网络
+	NoNetwork bool `json:"noNetwork,omitempty" yaml:"noNetwork,omitempty"`
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 安装包中的文件路径不安全，如绝对路径、使用..逃逸安装目录或通过符号链接指向安装目录之外
+type UnsafePathError struct {
+	// 安装包中的文件名
+	Entry string
+	// 原因
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return "Package: entry " + e.Entry + " unsafe: " + e.Reason
+}
+
+// 获得安装包中的文件在root中的路径
+// 文件名为绝对路径、使用..逃逸root，或父目录为指向root之外的符号链接时返回*UnsafePathError
+func securePath(root, name string) (string, error) {
+	cleaned, err := cleanEntry(name)
+	if err != nil {
+		return "", err
+	}
+	if cleaned == "." {
+		return root, nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	// 检查已解压的父目录，符号链接需指向root之内
+	cur := root
+	parts := strings.Split(cleaned, "/")
+	for _, p := range parts[:len(parts)-1] {
+		cur = filepath.Join(cur, p)
+		fi, err := os.Lstat(cur)
+		if err != nil {
+			break
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		real, err := filepath.EvalSymlinks(cur)
+		if err != nil || !withinDir(realRoot, real) {
+			return "", &UnsafePathError{Entry: name, Reason: "parent symlink resolves outside install directory"}
+		}
+	}
+	return filepath.Join(root, filepath.FromSlash(cleaned)), nil
+}
+
+// 检查root中的符号链接name，链接目标需为相对路径且位于root之内
+// 链接所在的目录可能是符号链接，按解析后的实际目录计算链接目标；
+// 目标中的..只能位于开头，避免经过其他符号链接后再使用..逃逸root，调用前链接所在的目录需已创建
+func secureLink(root, name, linkname string) error {
+	cleaned, err := cleanEntry(name)
+	if err != nil {
+		return err
+	}
+	target := strings.Replace(linkname, `\`, "/", -1)
+	if isAbsEntry(target) {
+		return &UnsafePathError{Entry: name, Reason: "symlink to absolute path " + linkname}
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path.Dir(cleaned))))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, parent)
+	if err != nil || !withinDir(realRoot, parent) {
+		return &UnsafePathError{Entry: name, Reason: "parent resolves outside install directory"}
+	}
+	depth := 0
+	if rel != "." {
+		depth = len(strings.Split(rel, string(filepath.Separator)))
+	}
+	up, climbing := 0, true
+	for _, p := range strings.Split(target, "/") {
+		switch p {
+		case "", ".":
+		case "..":
+			if !climbing {
+				return &UnsafePathError{Entry: name, Reason: "symlink " + linkname + " contains .. after path element"}
+			}
+			up++
+		default:
+			climbing = false
+		}
+	}
+	if up > depth {
+		return &UnsafePathError{Entry: name, Reason: "symlink " + linkname + " escapes install directory"}
+	}
+	return nil
+}
+
+// 清理安装包中的文件名，返回使用/分隔的相对路径
+func cleanEntry(name string) (string, error) {
+	slashed := strings.Replace(name, `\`, "/", -1)
+	if isAbsEntry(slashed) {
+		return "", &UnsafePathError{Entry: name, Reason: "absolute path"}
+	}
+	cleaned := path.Clean(slashed)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &UnsafePathError{Entry: name, Reason: "escapes install directory"}
+	}
+	return cleaned, nil
+}
+
+// 以/开头或以盘符（如C:）开头
+func isAbsEntry(name string) bool {
+	if strings.HasPrefix(name, "/") {
+		return true
+	}
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	c := name[0] | 0x20
+	return c >= 'a' && c <= 'z'
+}
+
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
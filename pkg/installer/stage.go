@@ -0,0 +1,178 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	io2 "github.com/xfali/goutils/io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 各格式安装器共用的安装目录及配置
+type baseInstaller struct {
+	installDir string
+	conf       config
+}
+
+// 应用配置并创建安装目录
+func newBaseInstaller(installDir string, opts []Opt) (baseInstaller, error) {
+	ret := baseInstaller{
+		installDir: installDir,
+		conf:       config{limits: DefaultExtractLimits},
+	}
+	for i := range opts {
+		opts[i](&ret.conf)
+	}
+	if !io2.IsPathExists(installDir) {
+		err := io2.Mkdir(installDir)
+		if err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// 安装包写入临时目录的函数，返回安装包的描述文件
+type stageFunc func(staging string) (*ZipPackageInfo, error)
+
+// 安装流程：stage先将安装包写入安装目录下的临时目录，全部文件写入并校验后检查用户，
+// 再移动到安装路径并设置所有者，写入临时目录失败时不残留文件
+// Param: pkg为格式相关字段（如KeepPkg）已设置的安装信息，path安装包路径，从数据流安装时为空
+// Return: 已生成安装路径时返回pkg，失败时调用者可以使用其卸载
+func (inst *baseInstaller) installStaged(pkg *ZipPackage, path string, strategy Strategy, stage stageFunc) (*ZipPackage, error) {
+	staging, err := ioutil.TempDir(inst.installDir, ".staging-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	info, err := stage(staging)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CheckUser(inst.conf.userPolicy, info)
+	if err != nil {
+		return nil, err
+	}
+	uid, gid, err := LookupOwner(info.User, info.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	saveDir, err := strategy.GenInstallPath(inst.installDir, info)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Name = info.Name
+	pkg.Version = info.AppVersion
+	pkg.Info = info.Info
+	pkg.PkgInfo = info
+	pkg.PkgPath = path
+	pkg.InstallPath = saveDir
+
+	err = os.MkdirAll(saveDir, 0755)
+	if err != nil {
+		return pkg, err
+	}
+	err = moveDir(staging, saveDir)
+	if err != nil {
+		return pkg, err
+	}
+	return pkg, chownAll(saveDir, uid, gid)
+}
+
+// 将src中的文件移动到dst，同名目录合并，同名文件覆盖
+func moveDir(src, dst string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		from, to := filepath.Join(src, fi.Name()), filepath.Join(dst, fi.Name())
+		if fi.IsDir() {
+			if cur, err := os.Lstat(to); err == nil && cur.IsDir() {
+				// 临时目录及安装目录中的目录可能不可写
+				err = os.Chmod(from, 0755)
+				if err != nil {
+					return err
+				}
+				err = os.Chmod(to, 0755)
+				if err != nil {
+					return err
+				}
+				err = moveDir(from, to)
+				if err != nil {
+					return err
+				}
+				err = os.Chmod(to, fi.Mode().Perm())
+				if err != nil {
+					return err
+				}
+				err = os.Chtimes(to, fi.ModTime(), fi.ModTime())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		err = os.RemoveAll(to)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(from, to)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dirAttr struct {
+	mode  os.FileMode
+	atime time.Time
+	mtime time.Time
+}
+
+// 安装包中目录的权限及修改时间，key为目录在临时目录中的相对路径
+// 写入文件会修改目录的修改时间，且目录可能不可写，所有文件写入后再调用apply设置
+type dirAttrs map[string]dirAttr
+
+func (d dirAttrs) set(name string, mode os.FileMode, atime, mtime time.Time) {
+	d[filepath.Clean(name)] = dirAttr{mode: mode.Perm(), atime: atime, mtime: mtime}
+}
+
+// 目录被删除或替换时不再设置
+func (d dirAttrs) remove(name string) {
+	delete(d, filepath.Clean(name))
+}
+
+// 设置dir中目录的权限及修改时间，子目录先于父目录设置，已不存在的目录忽略
+func (d dirAttrs) apply(dir string) error {
+	names := make([]string, 0, len(d))
+	for k := range d {
+		names = append(names, k)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		attr := d[name]
+		target := filepath.Join(dir, name)
+		err := os.Chmod(target, attr.mode)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		err = os.Chtimes(target, attr.atime, attr.mtime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package installer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// tar安装包，支持未压缩及gzip、xz、zstd压缩的tar包，根据文件头自动识别压缩格式
+// 安装时保留文件权限、修改时间及符号链接
+type TarInstaller struct {
+	baseInstaller
+}
+
+func CreateTarInstaller(installDir string, opts ...Opt) (*TarInstaller, error) {
+	base, err := newBaseInstaller(installDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TarInstaller{base}, nil
+}
+
+func (inst *TarInstaller) ReadInfo(path string) (PackageInfo, error) {
+	return getTarPackageInfo(path, newLimiter(inst.conf.limits))
+}
+
+func (inst *TarInstaller) Install(path string, strategy Strategy) (Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pkg, err := inst.install(f, path, strategy)
+	if pkg == nil {
+		return nil, err
+	}
+	return pkg, err
+}
+
+// tar可以流式读取，边读取边解压，不需要临时文件
+func (inst *TarInstaller) InstallFrom(r io.Reader, size int64, strategy Strategy) (Package, error) {
+	pkg, err := inst.install(r, "", strategy)
+	if pkg == nil {
+		return nil, err
+	}
+	return pkg, err
+}
+
+// 描述文件可能位于tar包中的任意位置，解压到临时目录后才能读取
+func (inst *TarInstaller) install(r io.Reader, path string, strategy Strategy) (*ZipPackage, error) {
+	return inst.installStaged(&ZipPackage{}, path, strategy, func(staging string) (*ZipPackageInfo, error) {
+		return extractTar(r, staging, newLimiter(inst.conf.limits))
+	})
+}
+
+// 解压tar包到目录，返回其中的描述文件，超过解压限制时返回*LimitError，
+// 文件与描述文件中的文件列表不一致时返回*IntegrityError
+func extractTar(r io.Reader, dir string, lim *limiter) (*ZipPackageInfo, error) {
+	dr, err := decompress(lim.countRaw(r))
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	var info *ZipPackageInfo
+	var verifier *fileVerifier
+	// 读取到描述文件前解压的文件，读取描述文件后校验
+	var unverified []string
+	var pending []*tar.Header
+	dirs := dirAttrs{}
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = lim.entry(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		entry := lim.reader(hdr.Name, tr, -1)
+		if info == nil && hdr.Typeflag == tar.TypeReg && IsManifestFile(hdr.Name) {
+			d, err := readManifestData(hdr.Name, entry)
+			if err != nil {
+				return nil, err
+			}
+			info, err = ParseManifest(hdr.Name, d)
+			if err != nil {
+				return nil, err
+			}
+			verifier = newFileVerifier(info)
+			for _, h := range pending {
+				err = verifyTarEntry(verifier, dir, h)
+				if err != nil {
+					return nil, err
+				}
+			}
+			pending = nil
+			entry = bytes.NewReader(d)
+		} else if info == nil {
+			if hdr.Typeflag == tar.TypeReg {
+				unverified = append(unverified, hdr.Name)
+			}
+			pending = append(pending, hdr)
+		} else if verifiable(hdr) {
+			err = verifier.check(hdr.Name, hdr.Typeflag == tar.TypeDir)
+			if err != nil {
+				return nil, err
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				entry = verifier.reader(hdr.Name, entry)
+			}
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs.set(hdr.Name, hdr.FileInfo().Mode(), accessTime(hdr), hdr.ModTime)
+		}
+		// 其他平台的文件解压后删除，不校验
+		verify := info
+		if info != nil {
+			if _, ok := platformPath(info, hdr.Name); !ok {
+				verify = nil
+			}
+		}
+		err = extractTarEntry(dir, hdr, entry, verify)
+		if err != nil {
+			return nil, err
+		}
+		// 链接在创建后校验
+		if verifier != nil {
+			switch hdr.Typeflag {
+			case tar.TypeSymlink:
+				err = verifier.link(hdr.Name, hdr.Linkname)
+			case tar.TypeLink:
+				err = verifier.file(hdr.Name, filepath.Join(dir, hdr.Name))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if info == nil {
+		return nil, errors.New("pkg.info not found")
+	}
+	err = verifier.done()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range unverified {
+		if _, ok := platformPath(info, f); !ok {
+			continue
+		}
+		err := verifyFile(filepath.Join(dir, f), info)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = dirs.apply(dir)
+	if err != nil {
+		return nil, err
+	}
+	return info, applyPlatform(dir, info)
+}
+
+func (inst *TarInstaller) Uninstall(pkg Package, del bool) error {
+	return pkg.Uninstall(del)
+}
+
+func getTarPackageInfo(path string, lim *limiter) (*ZipPackageInfo, error) {
+	var ret *ZipPackageInfo
+	err := walkTar(path, lim, func(hdr *tar.Header, r io.Reader) (bool, error) {
+		if hdr.Typeflag != tar.TypeReg || !IsManifestFile(hdr.Name) {
+			return false, nil
+		}
+		d, err := readManifestData(hdr.Name, r)
+		if err != nil {
+			return true, err
+		}
+		ret, err = ParseManifest(hdr.Name, d)
+		return true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		return nil, errors.New("pkg.info not found")
+	}
+	return ret, nil
+}
+
+// 按文件列表校验读取到描述文件前已解压的文件
+func verifyTarEntry(v *fileVerifier, dir string, hdr *tar.Header) error {
+	if !verifiable(hdr) {
+		return nil
+	}
+	err := v.check(hdr.Name, hdr.Typeflag == tar.TypeDir)
+	if err != nil {
+		return err
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeLink:
+		return v.file(hdr.Name, filepath.Join(dir, hdr.Name))
+	case tar.TypeSymlink:
+		return v.link(hdr.Name, hdr.Linkname)
+	}
+	return nil
+}
+
+// 需要列在文件列表中的文件，设备文件等其他类型不解压，pax全局头等不是文件
+func verifiable(hdr *tar.Header) bool {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeLink, tar.TypeSymlink, tar.TypeDir:
+		return true
+	}
+	return false
+}
+
+// 遍历tar包中的文件，f返回true时停止遍历
+// 描述文件之前的文件也需要解压后跳过，超过解压限制时返回*LimitError
+func walkTar(path string, lim *limiter, f func(hdr *tar.Header, r io.Reader) (bool, error)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, err := decompress(lim.countRaw(file))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = lim.entry(hdr.Name)
+		if err != nil {
+			return err
+		}
+		entry := lim.reader(hdr.Name, tr, -1)
+		stop, err := f(hdr, entry)
+		if stop || err != nil {
+			return err
+		}
+		// 跳过未读取的数据，tar.Reader跳过时不经过限制
+		_, err = io.Copy(ioutil.Discard, entry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 根据文件头识别压缩格式并返回解压后的数据流
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// 解压tar包中的文件，文件名或链接不安全时返回*UnsafePathError
+func extractTarEntry(saveDir string, hdr *tar.Header, r io.Reader, info *ZipPackageInfo) error {
+	filename, err := securePath(saveDir, hdr.Name)
+	if err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(filename, 0755)
+	case tar.TypeReg:
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			return err
+		}
+		// 不写入已存在的符号链接或硬链接指向的文件
+		os.Remove(filename)
+		w, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return err
+		}
+		err = copyFile(w, r, filename, info)
+		w.Close()
+		if err != nil {
+			return err
+		}
+		// 创建文件时的权限受umask影响，重新设置
+		err = os.Chmod(filename, mode.Perm())
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(filename, accessTime(hdr), hdr.ModTime)
+	case tar.TypeSymlink:
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			return err
+		}
+		err = secureLink(saveDir, hdr.Name, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		os.Remove(filename)
+		return os.Symlink(hdr.Linkname, filename)
+	case tar.TypeLink:
+		target, err := securePath(saveDir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			return err
+		}
+		os.Remove(filename)
+		return os.Link(target, filename)
+	}
+	// 忽略设备文件等其他类型
+	return nil
+}
+
+func accessTime(hdr *tar.Header) time.Time {
+	if hdr.AccessTime.IsZero() {
+		return hdr.ModTime
+	}
+	return hdr.AccessTime
+}
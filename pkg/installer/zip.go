@@ -7,13 +7,11 @@ package installer
 
 import (
 	"archive/zip"
-	"bytes"
-	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	io2 "github.com/xfali/goutils/io"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 )
@@ -22,6 +20,16 @@ const (
 	ZIP_INFO_FILENAME = "pkg.info"
 )
 
+// 安装包类型
+const (
+	// 由Supervisor运行的应用，默认类型
+	PackageTypeApp = ""
+	// Go插件，ExecName为使用-buildmode=plugin编译的.so文件，由宿主加载
+	PackageTypeGoPlugin = "goplugin"
+	// 进程外插件，由宿主启动并通过标准输入输出使用JSON-RPC调用
+	PackageTypeRPCPlugin = "rpcplugin"
+)
+
 type ZipPackageInfo struct {
 	ProtocolVersion int    `json:"protocolVersion" yaml:"protocolVersion"`
 	AppVersion      int    `json:"appVersion" yaml:"appVersion"`
@@ -30,7 +38,46 @@ type ZipPackageInfo struct {
 	Info            string `json:"info" yaml:"info"`
 	Description     string `json:"description" yaml:"description"`
 	ExecName        string `json:"execName" yaml:"execName"`
-	Checksum        string `json:"checksum" yaml:"checksum"`
+	// 可执行文件的校验值，格式为"算法:十六进制值"，支持md5、sha256、sha512，协议版本1中为md5值
+	Checksum string `json:"checksum" yaml:"checksum"`
+
+	// 重启策略
+	Restart *RestartPolicy `json:"restart,omitempty" yaml:"restart,omitempty"`
+	// 健康检查
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty" yaml:"healthCheck,omitempty"`
+	// 定时任务计划，声明后应用按计划运行，不使用重启策略
+	Schedule *Schedule `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// 应用的环境变量，值中可以使用与ExecCmd相同的变量
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// 环境变量文件，相对路径基于安装目录，每行格式为KEY=VALUE
+	EnvFile []string `json:"envFile,omitempty" yaml:"envFile,omitempty"`
+	// 运行应用及安装文件所属的用户，为空则使用当前用户
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// 运行应用及安装文件所属的用户组，为空则使用用户的主组
+	Group string `json:"group,omitempty" yaml:"group,omitempty"`
+	// 资源限制
+	Limits *ResourceLimits `json:"limits,omitempty" yaml:"limits,omitempty"`
+	// 沙箱，声明后应用运行在隔离的命名空间中
+	Sandbox *Sandbox `json:"sandbox,omitempty" yaml:"sandbox,omitempty"`
+	// 配置文件，相对路径基于安装目录，文件更新时应用将重新加载配置
+	ConfigFiles []string `json:"configFiles,omitempty" yaml:"configFiles,omitempty"`
+	// 配置文件更新时发送的信号，如SIGHUP，为空则重启应用
+	ReloadSignal string `json:"reloadSignal,omitempty" yaml:"reloadSignal,omitempty"`
+	// 停止应用时发送的信号：SIGTERM、SIGINT、SIGHUP，默认为SIGTERM
+	StopSignal string `json:"stopSignal,omitempty" yaml:"stopSignal,omitempty"`
+	// 发送停止信号或执行停止命令后等待退出的时间，超时则发送SIGKILL
+	StopTimeout Duration `json:"stopTimeout,omitempty" yaml:"stopTimeout,omitempty"`
+	// 停止命令，设置后使用该命令代替停止信号，支持与ExecCmd相同的变量
+	StopCmd string `json:"stopCmd,omitempty" yaml:"stopCmd,omitempty"`
+	// 安装包类型，为空则为应用
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Go插件声明的符号，加载插件时查找，如Init、Start、Stop
+	Symbols []string `json:"symbols,omitempty" yaml:"symbols,omitempty"`
+	// 平台相关的安装内容，key为GOOS-GOARCH，如linux-amd64，声明后只安装当前平台的目录
+	Platforms map[string]*Platform `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+	// 安装包中的全部文件（描述文件及目录除外），声明后安装时校验每个文件的长度及SHA-256，OCI镜像按合并后的文件校验，
+	// 拒绝未列出的文件并检查缺失的文件
+	Files []FileEntry `json:"files,omitempty" yaml:"files,omitempty"`
 }
 
 type ZipPackage struct {
@@ -40,23 +87,24 @@ type ZipPackage struct {
 
 	PkgPath     string `json:"pkgPath" yaml:"pkgPath"`
 	InstallPath string `json:"installPath" yaml:"installPath"`
+
+	PkgInfo *ZipPackageInfo `json:"pkgInfo,omitempty" yaml:"pkgInfo,omitempty"`
+	// 卸载时保留安装包，如目录安装包
+	KeepPkg bool `json:"keepPkg,omitempty" yaml:"keepPkg,omitempty"`
+	// OCI镜像安装包的manifest摘要，如sha256:...
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
 }
 
 type ZipInstaller struct {
-	installDir string
+	baseInstaller
 }
 
-func CreateInstaller(installDir string) (*ZipInstaller, error) {
-	ret := &ZipInstaller{
-		installDir: installDir,
-	}
-	if !io2.IsPathExists(installDir) {
-		err := io2.Mkdir(installDir)
-		if err != nil {
-			return nil, err
-		}
+func CreateInstaller(installDir string, opts ...Opt) (*ZipInstaller, error) {
+	base, err := newBaseInstaller(installDir, opts)
+	if err != nil {
+		return nil, err
 	}
-	return ret, nil
+	return &ZipInstaller{base}, nil
 }
 
 func (inst *ZipInstaller) ReadInfo(path string) (PackageInfo, error) {
@@ -64,35 +112,88 @@ func (inst *ZipInstaller) ReadInfo(path string) (PackageInfo, error) {
 }
 
 func (inst *ZipInstaller) Install(path string, strategy Strategy) (Package, error) {
-	pkg := &ZipPackage{}
-	info, err := getPackageInfo(path)
+	reader, err := zip.OpenReader(path)
 	if err != nil {
 		return nil, err
 	}
-	pkg.Name = info.Name
-	pkg.Version = info.AppVersion
-	pkg.Info = info.Info
+	defer reader.Close()
+	pkg, err := inst.install(&reader.Reader, path, strategy)
+	if pkg == nil {
+		return nil, err
+	}
+	return pkg, err
+}
 
-	saveDir, err := strategy.GenInstallPath(inst.installDir, info)
+// zip需要随机读取，r未实现io.ReaderAt或者size未知时先写入临时文件
+func (inst *ZipInstaller) InstallFrom(r io.Reader, size int64, strategy Strategy) (Package, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok || size < 0 {
+		f, err := spill(r)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		ra, size = f, fi.Size()
+	}
+	reader, err := zip.NewReader(ra, size)
 	if err != nil {
 		return nil, err
 	}
-	pkg.PkgPath = path
-	pkg.InstallPath = saveDir
+	pkg, err := inst.install(reader, "", strategy)
+	if pkg == nil {
+		return nil, err
+	}
+	return pkg, err
+}
 
-	reader, err := zip.OpenReader(path)
+func (inst *ZipInstaller) install(reader *zip.Reader, path string, strategy Strategy) (*ZipPackage, error) {
+	info, err := readZipManifest(reader)
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close()
+	return inst.installStaged(&ZipPackage{}, path, strategy, func(staging string) (*ZipPackageInfo, error) {
+		return info, extractZip(reader, staging, info, newLimiter(inst.conf.limits))
+	})
+}
+
+// 解压zip包到目录，文件名不安全时返回*UnsafePathError，超过解压限制时返回*LimitError，
+// 文件与描述文件中的文件列表不一致时返回*IntegrityError
+func extractZip(reader *zip.Reader, dir string, info *ZipPackageInfo, lim *limiter) error {
+	verifier := newFileVerifier(info)
 	for _, file := range reader.File {
-		err := func() error {
+		err := lim.entry(file.Name)
+		if err != nil {
+			return err
+		}
+		err = verifier.check(file.Name, file.FileInfo().IsDir())
+		if err != nil {
+			return err
+		}
+		// 跳过其他平台的文件，当前平台的文件放置在安装目录下
+		name, ok := platformPath(info, file.Name)
+		if !ok {
+			continue
+		}
+		filename, err := securePath(dir, name)
+		if err != nil {
+			return err
+		}
+		err = func() error {
+			if file.FileInfo().IsDir() {
+				return os.MkdirAll(filename, 0755)
+			}
 			rc, err := file.Open()
 			if err != nil {
 				return err
 			}
 			defer rc.Close()
-			filename := filepath.Join(saveDir, file.Name)
 			dir := filepath.Dir(filename)
 			if dir != "" && dir != "." {
 				err = os.MkdirAll(dir, 0755)
@@ -101,31 +202,58 @@ func (inst *ZipInstaller) Install(path string, strategy Strategy) (Package, erro
 				}
 			}
 
+			// 不写入已存在的符号链接指向的文件
+			os.Remove(filename)
 			w, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, file.Mode())
 			if err != nil {
 				return err
 			}
 			defer w.Close()
-			if filepath.Base(filename) == info.ExecName && info.Checksum != "" {
-				h := md5.New()
-				multiWriter := io.MultiWriter(w, h)
-				_, err = io.Copy(multiWriter, rc)
-				if err != nil {
-					return err
-				}
-				if hex.EncodeToString(h.Sum(nil)) != info.Checksum {
-					return errors.New("Checksum not match ")
-				}
-				return nil
-			}
-			_, err = io.Copy(w, rc)
-			return err
+			// 按实际解压的数据检查限制，不信任zip头中声明的大小
+			r := verifier.reader(file.Name, lim.reader(file.Name, rc, int64(file.CompressedSize64)))
+			return copyFile(w, r, filename, info)
 		}()
 		if err != nil {
-			return pkg, err
+			return err
+		}
+	}
+	return verifier.done()
+}
+
+// 将数据流写入临时文件
+func spill(r io.Reader) (*os.File, error) {
+	f, err := ioutil.TempFile("", "magnet-pkg")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// 写入安装文件，如果是可执行文件且声明了checksum则同时校验，info为nil时不校验
+func copyFile(w io.Writer, r io.Reader, filename string, info *ZipPackageInfo) error {
+	if info != nil && filepath.Base(filename) == info.ExecName && info.Checksum != "" {
+		h, sum, err := parseChecksum(info.Checksum)
+		if err != nil {
+			return err
+		}
+		multiWriter := io.MultiWriter(w, h)
+		_, err = io.Copy(multiWriter, r)
+		if err != nil {
+			return err
 		}
+		if hex.EncodeToString(h.Sum(nil)) != sum {
+			return errors.New("Checksum not match ")
+		}
+		return nil
 	}
-	return pkg, nil
+	_, err := io.Copy(w, r)
+	return err
 }
 
 func checkPackage(file string, checksum string) error {
@@ -137,14 +265,18 @@ func checkPackage(file string, checksum string) error {
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	h := md5.New()
+	h, sum, err := parseChecksum(checksum)
+	if err != nil {
+		return err
+	}
 	_, err = io.Copy(h, f)
 	if err != nil {
 		return err
 	}
 
-	if hex.EncodeToString(h.Sum(nil)) != checksum {
+	if hex.EncodeToString(h.Sum(nil)) != sum {
 		return errors.New("Checksum not match ")
 	}
 	return nil
@@ -156,21 +288,23 @@ func getPackageInfo(path string) (*ZipPackageInfo, error) {
 		return nil, err
 	}
 	defer reader.Close()
+	return readZipManifest(&reader.Reader)
+}
+
+func readZipManifest(reader *zip.Reader) (*ZipPackageInfo, error) {
 	for _, file := range reader.File {
-		if filepath.Base(file.Name) == ZIP_INFO_FILENAME {
+		if IsManifestFile(file.Name) {
 			return func() (*ZipPackageInfo, error) {
 				rc, err := file.Open()
 				if err != nil {
 					return nil, err
 				}
 				defer rc.Close()
-				var buf bytes.Buffer
-				buf.Grow(int(file.UncompressedSize64))
-				_, err = buf.ReadFrom(rc)
+				d, err := readManifestData(file.Name, rc)
 				if err != nil {
 					return nil, err
 				}
-				return readZipInfo(buf.Bytes())
+				return ParseManifest(file.Name, d)
 			}()
 		}
 	}
@@ -195,6 +329,16 @@ func (r *ZipPackageInfo) GetDescription() string {
 	return r.Description
 }
 
+// 获得运行应用的用户
+func (r *ZipPackageInfo) GetUser() string {
+	return r.User
+}
+
+// 获得运行应用的用户组
+func (r *ZipPackageInfo) GetGroup() string {
+	return r.Group
+}
+
 func (pkg *ZipPackage) GetName() string {
 	return pkg.Name
 }
@@ -211,12 +355,38 @@ func (pkg *ZipPackage) GetInstallPath() string {
 	return pkg.InstallPath
 }
 
+// 获得安装包描述信息，在安装或读取安装记录时设置
+func (pkg *ZipPackage) GetPackageInfo() PackageInfo {
+	if pkg.PkgInfo == nil {
+		return nil
+	}
+	return pkg.PkgInfo
+}
+
+// 记录中没有描述信息（旧版本的安装记录）时从安装目录中的描述文件读取
+func (pkg *ZipPackage) loadPackageInfo() {
+	if pkg.PkgInfo != nil {
+		return
+	}
+	for _, name := range ManifestFileNames {
+		d, err := ioutil.ReadFile(filepath.Join(pkg.InstallPath, name))
+		if err != nil {
+			continue
+		}
+		info, err := ParseManifest(name, d)
+		if err == nil {
+			pkg.PkgInfo = info
+		}
+		return
+	}
+}
+
 func (pkg *ZipPackage) Uninstall(delPkg bool) (err error) {
 	if io2.IsPathExists(pkg.InstallPath) {
 		err = os.RemoveAll(pkg.InstallPath)
 	}
 
-	if delPkg && io2.IsPathExists(pkg.PkgPath) {
+	if delPkg && !pkg.KeepPkg && io2.IsPathExists(pkg.PkgPath) {
 		err = os.RemoveAll(pkg.PkgPath)
 	}
 	return err
@@ -238,15 +408,6 @@ func (pkg *ZipPackage) Equal(other Package) bool {
 	return true
 }
 
-func readZipInfo(data []byte) (*ZipPackageInfo, error) {
-	ret := &ZipPackageInfo{}
-	err := json.Unmarshal(data, ret)
-	if err != nil {
-		return nil, err
-	}
-	return ret, nil
-}
-
 type DefaultStrategy struct{}
 
 func NewStrategy() *DefaultStrategy {
@@ -0,0 +1,301 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package rpcplugin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 插件进程的环境变量，值为协议版本，插件通过该变量判断是否由宿主启动
+	EnvPlugin = "MAGNET_RPC_PLUGIN"
+	// 当前协议版本
+	ProtocolVersion = 1
+
+	// 握手信息的前缀，插件启动后向标准输出写入"magnet-rpc-plugin 协议版本"
+	handshakePrefix = "magnet-rpc-plugin"
+)
+
+// 插件进程握手失败，如启动的程序不是插件或协议版本不一致
+type HandshakeError struct {
+	Name string
+	Err  error
+}
+
+func (e *HandshakeError) Error() string {
+	return "Plugin: " + e.Name + " handshake failed: " + e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// 插件进程的客户端，通过标准输入输出使用JSON-RPC调用插件
+// 插件升级时通过Swap替换插件进程，正在进行的调用完成后停止旧进程，宿主不需要重启
+type Client struct {
+	name string
+	conf config
+
+	cur    *conn
+	closed bool
+	lock   sync.RWMutex
+}
+
+type config struct {
+	handshakeTimeout time.Duration
+	stopTimeout      time.Duration
+}
+
+type Opt func(c *config)
+
+// 插件进程
+type conn struct {
+	cmd    *exec.Cmd
+	client *rpc.Client
+	// 正在进行的调用
+	calls sync.WaitGroup
+	// 进程退出时关闭
+	done chan struct{}
+}
+
+// 启动插件进程并握手
+// param: name插件名称，cmd插件的启动命令，标准输入输出用于RPC，未设置Stderr时使用宿主的标准错误输出
+func Start(name string, cmd *exec.Cmd, opts ...Opt) (*Client, error) {
+	ret := &Client{
+		name: name,
+		conf: config{
+			handshakeTimeout: 10 * time.Second,
+			stopTimeout:      5 * time.Second,
+		},
+	}
+	for i := range opts {
+		opts[i](&ret.conf)
+	}
+	c, err := ret.start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	ret.cur = c
+	return ret, nil
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+// 获得当前插件进程的pid
+func (c *Client) Pid() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.cur == nil {
+		return 0
+	}
+	return c.cur.cmd.Process.Pid
+}
+
+// 调用插件的方法，serviceMethod格式为"服务名.方法名"
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	c.lock.RLock()
+	if c.closed {
+		c.lock.RUnlock()
+		return errors.New("Plugin: " + c.name + " closed ")
+	}
+	cur := c.cur
+	cur.calls.Add(1)
+	c.lock.RUnlock()
+
+	defer cur.calls.Done()
+	return cur.client.Call(serviceMethod, args, reply)
+}
+
+// 启动新的插件进程替换当前进程，新进程握手成功后新的调用使用新进程，旧进程在正在进行的调用完成后停止
+// 新进程启动失败时继续使用旧进程
+func (c *Client) Swap(cmd *exec.Cmd) error {
+	n, err := c.start(cmd)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		n.stop(c.conf.stopTimeout)
+		return errors.New("Plugin: " + c.name + " closed ")
+	}
+	old := c.cur
+	c.cur = n
+	c.lock.Unlock()
+
+	old.calls.Wait()
+	return old.stop(c.conf.stopTimeout)
+}
+
+// 停止插件进程
+func (c *Client) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	cur := c.cur
+	c.lock.Unlock()
+
+	cur.calls.Wait()
+	return cur.stop(c.conf.stopTimeout)
+}
+
+func (c *Client) start(cmd *exec.Cmd) (*conn, error) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, EnvPlugin+"="+strconv.Itoa(ProtocolVersion))
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	// 不使用StdoutPipe，Wait时会关闭读取端，导致进程退出前写入的响应丢失
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, err
+	}
+	cmd.Stdin = inR
+	cmd.Stdout = outW
+	err = cmd.Start()
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return nil, err
+	}
+
+	ret := &conn{
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(ret.done)
+	}()
+
+	br := bufio.NewReader(outR)
+	err = c.handshake(br, cmd.Stderr)
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		cmd.Process.Kill()
+		<-ret.done
+		return nil, &HandshakeError{Name: c.name, Err: err}
+	}
+	ret.client = rpc.NewClientWithCodec(jsonrpc.NewClientCodec(&stdio{
+		Reader:  br,
+		Writer:  inW,
+		closers: []io.Closer{inW, outR},
+	}))
+	return ret, nil
+}
+
+// 读取握手信息，握手前插件写入标准输出的内容转发到stderr
+func (c *Client) handshake(r *bufio.Reader, stderr io.Writer) error {
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err == nil && !strings.HasPrefix(line, handshakePrefix+" ") {
+				stderr.Write([]byte(line))
+				continue
+			}
+			ch <- result{line: line, err: err}
+			return
+		}
+	}()
+
+	var ret result
+	select {
+	case ret = <-ch:
+	case <-time.After(c.conf.handshakeTimeout):
+		return errors.New("timeout")
+	}
+	if ret.err != nil {
+		return ret.err
+	}
+	fields := strings.Fields(ret.line)
+	if len(fields) != 2 {
+		return fmt.Errorf("unexpected handshake: %q", ret.line)
+	}
+	v, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("unexpected handshake: %q", ret.line)
+	}
+	if v != ProtocolVersion {
+		return fmt.Errorf("protocol version %d not supported, host version: %d", v, ProtocolVersion)
+	}
+	return nil
+}
+
+// 关闭标准输入通知插件退出，超时则结束进程
+func (c *conn) stop(timeout time.Duration) error {
+	c.client.Close()
+	select {
+	case <-c.done:
+		return nil
+	case <-time.After(timeout):
+	}
+	err := c.cmd.Process.Kill()
+	<-c.done
+	return err
+}
+
+type stdio struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (s *stdio) Close() error {
+	var ret error
+	for _, c := range s.closers {
+		err := c.Close()
+		if err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// 设置握手超时时间，插件进程启动后需在该时间内完成握手，默认为10秒
+func SetHandshakeTimeout(t time.Duration) Opt {
+	return func(c *config) {
+		c.handshakeTimeout = t
+	}
+}
+
+// 设置停止插件进程时等待退出的时间，超时则结束进程，默认为5秒
+func SetStopTimeout(t time.Duration) Opt {
+	return func(c *config) {
+		c.stopTimeout = t
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package rpcplugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Echo struct {
+	version string
+}
+
+func (e *Echo) Say(args string, reply *string) error {
+	*reply = e.version + ":" + args
+	return nil
+}
+
+func (e *Echo) Sleep(d time.Duration, reply *string) error {
+	time.Sleep(d)
+	*reply = e.version
+	return nil
+}
+
+// 作为插件进程运行
+func TestHelperPlugin(t *testing.T) {
+	if os.Getenv(EnvPlugin) == "" {
+		return
+	}
+	// 握手前写入标准输出的内容转发到宿主的标准错误输出
+	fmt.Println("plugin started")
+	err := Serve(&Echo{version: os.Getenv("ECHO_VERSION")})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func pluginCmd(version string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperPlugin$")
+	cmd.Env = append(os.Environ(), "ECHO_VERSION="+version)
+	return cmd
+}
+
+func TestClient(t *testing.T) {
+	c, err := Start("echo", pluginCmd("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var reply string
+	err = c.Call("Echo.Say", "hello", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "v1:hello" {
+		t.Fatal("expect v1:hello, got ", reply)
+	}
+
+	// 替换进程时正在进行的调用不受影响
+	oldPid := c.Pid()
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var r string
+			errs <- c.Call("Echo.Sleep", 200*time.Millisecond, &r)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	err = c.Swap(pluginCmd("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Pid() == oldPid {
+		t.Fatal("expect new process")
+	}
+	err = c.Call("Echo.Say", "hello", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "v2:hello" {
+		t.Fatal("expect v2:hello, got ", reply)
+	}
+
+	// 启动失败时继续使用旧进程
+	err = c.Swap(exec.Command("sh", "-c", "echo not a plugin"))
+	var herr *HandshakeError
+	if !errors.As(err, &herr) {
+		t.Fatal("expect HandshakeError, got ", err)
+	}
+	t.Log(err)
+	err = c.Call("Echo.Say", "again", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "v2:again" {
+		t.Fatal("expect v2:again, got ", reply)
+	}
+
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Call("Echo.Say", "hello", &reply) == nil {
+		t.Fatal("expect closed error")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	_, err := Start("sleep", exec.Command("sleep", "10"), SetHandshakeTimeout(100*time.Millisecond))
+	var herr *HandshakeError
+	if !errors.As(err, &herr) {
+		t.Fatal("expect HandshakeError, got ", err)
+	}
+	t.Log(err)
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package rpcplugin
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"strconv"
+)
+
+// 在插件进程中注册服务，并通过标准输入输出提供JSON-RPC服务，宿主关闭连接后返回
+// 服务名为接收者的类型名，方法需满足net/rpc的要求
+// 标准输出用于RPC，调用后os.Stdout重定向到标准错误输出，插件不能直接写入文件描述符1
+func Serve(rcvrs ...interface{}) error {
+	v := os.Getenv(EnvPlugin)
+	if v == "" {
+		return errors.New("Plugin: not started by magnet host, " + EnvPlugin + " not set ")
+	}
+	if v != strconv.Itoa(ProtocolVersion) {
+		return errors.New("Plugin: host protocol version " + v + " not supported ")
+	}
+	server := rpc.NewServer()
+	for _, r := range rcvrs {
+		err := server.Register(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	_, err := fmt.Fprintf(out, "%s %d\n", handshakePrefix, ProtocolVersion)
+	if err != nil {
+		return err
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(&stdio{Reader: os.Stdin, Writer: out}))
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExecCmd、stopCmd、健康检查命令及env中可使用的变量
+const (
+	// 可执行文件路径，即安装目录下的ExecName
+	VarExecutable = "EXECUTABLE"
+	// 安装目录
+	VarInstallPath = "INSTALL_PATH"
+	// 安装包名称
+	VarPkgName = "PKG_NAME"
+	// 安装包版本号
+	VarPkgVersion = "PKG_VERSION"
+	// 应用数据目录，位于安装目录旁，卸载时不会删除
+	VarDataDir = "DATA_DIR"
+	// 应用日志目录，位于安装目录旁
+	VarLogDir = "LOG_DIR"
+)
+
+// 应用的运行参数，可用于调试启动命令及环境变量
+type ExecSpec struct {
+	// 展开变量后的启动命令
+	Args []string
+	// 应用的环境变量，格式为KEY=VALUE
+	Env []string
+	// 工作目录，即安装目录
+	Dir string
+	// 运行进程的用户id及用户组id，-1为使用当前用户或用户组
+	Uid int
+	Gid int
+
+	vars map[string]string
+	env  map[string]string
+}
+
+// 获得应用的数据目录，位于安装目录旁
+func DataDir(installPath string) string {
+	return filepath.Clean(installPath) + ".data"
+}
+
+// 生成应用的运行参数
+// 环境变量优先级由低到高为：当前进程环境变量、envFile（按声明顺序）、env、hostEnv
+// Param: hostEnv 宿主设置的环境变量，覆盖安装包中声明的同名变量
+func BuildSpec(pkg installer.Package, info *installer.ZipPackageInfo, hostEnv map[string]string) (*ExecSpec, error) {
+	uid, gid, err := installer.LookupOwner(info.User, info.Group)
+	if err != nil {
+		return nil, err
+	}
+	if (uid >= 0 || gid >= 0) && !credentialSupported {
+		return nil, errors.New("Package: " + pkg.GetName() + " run as user is not supported ")
+	}
+
+	installPath := pkg.GetInstallPath()
+	ret := &ExecSpec{
+		Dir: installPath,
+		Uid: uid,
+		Gid: gid,
+		vars: map[string]string{
+			VarExecutable:  filepath.Join(installPath, info.ExecName),
+			VarInstallPath: installPath,
+			VarPkgName:     pkg.GetName(),
+			VarPkgVersion:  strconv.Itoa(pkg.GetVersion()),
+			VarDataDir:     DataDir(installPath),
+			VarLogDir:      LogDir(installPath),
+		},
+		env: map[string]string{},
+	}
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		if i > 0 {
+			ret.env[kv[:i]] = kv[i+1:]
+		}
+	}
+	for _, f := range info.EnvFile {
+		f = ret.expand(f)
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(installPath, f)
+		}
+		env, err := ReadEnvFile(f)
+		if err != nil {
+			return nil, err
+		}
+		ret.setEnv(env)
+	}
+	ret.setEnv(info.Env)
+	ret.setEnv(hostEnv)
+
+	keys := make([]string, 0, len(ret.env))
+	for k := range ret.env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ret.Env = append(ret.Env, k+"="+ret.env[k])
+	}
+
+	ret.Args = ret.Expand(info.ExecCmd)
+	if len(ret.Args) == 0 {
+		ret.Args = []string{ret.vars[VarExecutable]}
+	}
+	return ret, nil
+}
+
+func (spec *ExecSpec) setEnv(env map[string]string) {
+	for k, v := range env {
+		spec.env[k] = spec.expand(v)
+	}
+}
+
+func (spec *ExecSpec) expand(s string) string {
+	return os.Expand(s, func(key string) string {
+		if v, ok := spec.vars[key]; ok {
+			return v
+		}
+		return spec.env[key]
+	})
+}
+
+// 按空白拆分命令行并展开其中的变量，变量优先使用内置变量，其次为应用的环境变量
+func (spec *ExecSpec) Expand(cmdline string) []string {
+	args := strings.Fields(cmdline)
+	for i := range args {
+		args[i] = spec.expand(args[i])
+	}
+	return args
+}
+
+// 获得内置变量的值
+func (spec *ExecSpec) Var(key string) string {
+	return spec.vars[key]
+}
+
+// 生成启动命令
+func (spec *ExecSpec) Command() *exec.Cmd {
+	return spec.command(spec.Args)
+}
+
+func (spec *ExecSpec) command(args []string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = spec.Dir
+	cmd.Env = spec.Env
+	setCredential(cmd, spec.Uid, spec.Gid)
+	return cmd
+}
+
+func (spec *ExecSpec) commandContext(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = spec.Dir
+	cmd.Env = spec.Env
+	setCredential(cmd, spec.Uid, spec.Gid)
+	return cmd
+}
+
+// 读取环境变量文件，每行格式为KEY=VALUE，忽略空行及#开头的注释行
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			continue
+		}
+		k, v := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		ret[k] = v
+	}
+	return ret, scanner.Err()
+}
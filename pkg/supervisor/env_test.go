@@ -0,0 +1,47 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildSpec(t *testing.T) {
+	pkg := createPackage(t, "")
+	err := ioutil.WriteFile(filepath.Join(pkg.InstallPath, "app.env"), []byte("# comment\nA=file\nexport B=\"file\"\nC=file\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.PkgInfo.ExecCmd = "${EXECUTABLE} --data ${DATA_DIR} --name ${PKG_NAME}-${PKG_VERSION} ${C}"
+	pkg.PkgInfo.EnvFile = []string{"app.env"}
+	pkg.PkgInfo.Env = map[string]string{"B": "pkg", "C": "pkg", "LOG": "${LOG_DIR}/app.log"}
+
+	spec, err := BuildSpec(pkg, pkg.PkgInfo, map[string]string{"C": "host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{filepath.Join(pkg.InstallPath, "run.sh"), "--data", DataDir(pkg.InstallPath), "--name", "test-1", "host"}
+	if len(spec.Args) != len(expect) {
+		t.Fatal("unexpected args: ", spec.Args)
+	}
+	for i := range expect {
+		if spec.Args[i] != expect[i] {
+			t.Fatal("unexpected args: ", spec.Args)
+		}
+	}
+
+	env := map[string]bool{}
+	for _, v := range spec.Env {
+		env[v] = true
+	}
+	for _, v := range []string{"A=file", "B=pkg", "C=host", "LOG=" + LogDir(pkg.InstallPath) + "/app.log"} {
+		if !env[v] {
+			t.Fatal("expect env: ", v, " got: ", spec.Env)
+		}
+	}
+}
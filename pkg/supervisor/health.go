@@ -0,0 +1,141 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xfali/magnet/pkg/installer"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// 默认健康检查间隔
+	DefaultHealthInterval = time.Second
+	// 默认单次健康检查超时时间
+	DefaultHealthTimeout = 3 * time.Second
+	// 默认连续失败阈值
+	DefaultHealthThreshold = 3
+	// 默认等待应用健康的总超时时间
+	DefaultHealthWaitTimeout = 5 * time.Minute
+)
+
+// 执行一次健康检查，健康返回nil
+func Probe(spec *ExecSpec, hc *installer.HealthCheck) error {
+	timeout := hc.Timeout.Duration()
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch {
+	case hc.Exec != "":
+		return spec.commandContext(ctx, spec.Expand(hc.Exec)).Run()
+	case hc.TCPPort > 0:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("localhost", strconv.Itoa(hc.TCPPort)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case hc.HTTPGet != "":
+		u, err := url.Parse(hc.HTTPGet)
+		if err != nil {
+			return err
+		}
+		if !isLocalHost(u.Hostname()) {
+			return errors.New("Health check url must be local: " + hc.HTTPGet)
+		}
+		req, err := http.NewRequest(http.MethodGet, hc.HTTPGet, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("Health check %s status: %d ", hc.HTTPGet, resp.StatusCode)
+		}
+		return nil
+	}
+	return errors.New("Health check of package: " + spec.Var(VarPkgName) + " is empty ")
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// 启动宽限期内的检查失败不计入连续失败次数，超过总超时时间仍未健康时返回错误
+func (s *ProcessSupervisor) WaitHealthy(name string) error {
+	s.lock.Lock()
+	p, ok := s.procs[name]
+	s.lock.Unlock()
+	if !ok {
+		return errors.New("Package: " + name + " is not running ")
+	}
+	hc := p.info.HealthCheck
+	if hc == nil {
+		return nil
+	}
+	interval := hc.Interval.Duration()
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	threshold := hc.Threshold
+	if threshold <= 0 {
+		threshold = DefaultHealthThreshold
+	}
+	waitTimeout := hc.WaitTimeout.Duration()
+	if waitTimeout <= 0 {
+		waitTimeout = DefaultHealthWaitTimeout
+	}
+	s.lock.Lock()
+	startTime := p.status.StartTime
+	s.lock.Unlock()
+	startPeriod := startTime.Add(hc.StartPeriod.Duration())
+	deadline := startTime.Add(waitTimeout)
+
+	failures := 0
+	for {
+		st, _ := s.Status(name)
+		if st.State != StateRunning && st.State != StateBackoff {
+			return fmt.Errorf("Package: %s is %s, exit code: %d ", name, st.State, st.ExitCode)
+		}
+		s.lock.Lock()
+		spec := p.spec
+		s.lock.Unlock()
+		err := Probe(spec, hc)
+		if err == nil {
+			return nil
+		}
+		now := time.Now()
+		if now.Before(startPeriod) {
+			s.log.Debugf("Package: %s health check failed in start period: %v\n", name, err)
+		} else {
+			failures++
+			s.log.Warnf("Package: %s health check failed %d times: %v\n", name, failures, err)
+			if failures >= threshold {
+				return fmt.Errorf("Package: %s is unhealthy: %v ", name, err)
+			}
+		}
+		if !now.Add(interval).Before(deadline) {
+			return fmt.Errorf("Package: %s is not healthy after %v: %v ", name, waitTimeout, err)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// 宿主已调用Init，可以使用初始化进程
+var initEnabled bool
+
+// 声明了资源限制或沙箱的应用通过重新执行宿主程序（/proc/self/exe）作为初始化进程启动，
+// 使用资源限制或沙箱的宿主须在main函数开始处、其他初始化之前调用Init：
+//
+//	func main() {
+//		supervisor.Init()
+//		...
+//	}
+//
+// 当前进程为初始化进程时Init不会返回，执行应用或失败退出；否则立即返回。
+// 未调用Init时启动声明了资源限制或沙箱的应用将返回错误。
+func Init() {
+	runInit()
+	initEnabled = true
+}
+
+// 应用的初始化进程，宿主启动该进程并设置资源限制后通知其继续，由其完成沙箱设置、切换用户并执行应用
+// 应用及其子进程从第一条指令起即受资源限制
+type initProcess struct {
+	name string
+	// 初始化进程执行应用失败时写入错误信息，执行成功时随exec关闭
+	status *os.File
+	// 宿主写入后初始化进程继续执行，未写入即关闭则初始化进程退出
+	resume *os.File
+	// 子进程持有的另一端，进程启动后宿主关闭
+	child []*os.File
+}
+
+// 进程启动后关闭子进程持有的一端，启动失败时也需调用
+func (p *initProcess) started() {
+	if p == nil {
+		return
+	}
+	for _, f := range p.child {
+		f.Close()
+	}
+	p.child = nil
+}
+
+// 通知初始化进程继续执行，并等待其执行应用
+func (p *initProcess) Resume() error {
+	if p == nil {
+		return nil
+	}
+	defer p.status.Close()
+	_, err := p.resume.Write([]byte{1})
+	p.resume.Close()
+	if err != nil {
+		return err
+	}
+	msg, err := ioutil.ReadAll(p.status)
+	if err != nil {
+		return err
+	}
+	if len(msg) > 0 {
+		return errors.New("Package: " + p.name + " init failed: " + string(msg))
+	}
+	return nil
+}
+
+// 放弃执行应用，初始化进程将退出
+func (p *initProcess) Close() {
+	if p == nil {
+		return
+	}
+	p.started()
+	p.resume.Close()
+	p.status.Close()
+}
@@ -0,0 +1,168 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xfali/magnet/pkg/installer"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// 初始化进程参数的环境变量，存在该变量时当前进程作为初始化进程运行
+	initEnv = "MAGNET_INIT"
+)
+
+type initConfig struct {
+	// 应用可执行文件路径及参数
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	// 沙箱中只读挂载的目录，即安装目录，为空则不使用沙箱
+	SandboxRoot string `json:"sandboxRoot,omitempty"`
+	// 运行应用的用户，为nil则不切换用户；沙箱挂载需要root权限，挂载完成后再切换
+	Credential *syscall.Credential `json:"credential,omitempty"`
+	// 等待宿主设置资源限制的文件描述符
+	ResumeFd int `json:"resumeFd"`
+	// 向宿主报告初始化结果的文件描述符
+	StatusFd int `json:"statusFd"`
+}
+
+// 初始化进程等待宿主设置资源限制及cgroup，完成沙箱挂载并切换用户后执行应用
+func runInit() {
+	d, ok := os.LookupEnv(initEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(initEnv)
+	conf := initConfig{ResumeFd: -1, StatusFd: -1}
+	err := json.Unmarshal([]byte(d), &conf)
+	if err == nil {
+		err = initExec(conf)
+	}
+	// 执行成功则不会返回
+	fmt.Fprintf(os.Stderr, "magnet init: %v\n", err)
+	if conf.StatusFd >= 0 {
+		syscall.Write(conf.StatusFd, []byte(err.Error()))
+	}
+	os.Exit(127)
+}
+
+func initExec(conf initConfig) error {
+	if conf.ResumeFd >= 0 {
+		f := os.NewFile(uintptr(conf.ResumeFd), "resume")
+		n, _ := f.Read(make([]byte, 1))
+		f.Close()
+		if n == 0 {
+			return errors.New("aborted by host")
+		}
+	}
+	if conf.SandboxRoot != "" {
+		err := sandboxMount(conf.SandboxRoot)
+		if err != nil {
+			return err
+		}
+	}
+	if conf.Credential != nil {
+		// 只切换当前线程的用户，exec后新的进程使用当前线程的用户
+		runtime.LockOSThread()
+		err := setThreadCredential(conf.Credential)
+		if err != nil {
+			return err
+		}
+	}
+	if conf.StatusFd >= 0 {
+		syscall.CloseOnExec(conf.StatusFd)
+	}
+	err := syscall.Exec(conf.Path, conf.Args, os.Environ())
+	return fmt.Errorf("exec %s: %v", conf.Path, err)
+}
+
+// 切换当前线程的用户及用户组
+// Go 1.16之前syscall.Setuid等在Linux中不支持（返回EOPNOTSUPP），与syscall.ForkExec的子进程相同，直接调用系统调用
+func setThreadCredential(cred *syscall.Credential) error {
+	if !cred.NoSetGroups {
+		var p unsafe.Pointer
+		if len(cred.Groups) > 0 {
+			p = unsafe.Pointer(&cred.Groups[0])
+		}
+		_, _, errno := syscall.RawSyscall(sysSetgroups, uintptr(len(cred.Groups)), uintptr(p), 0)
+		if errno != 0 {
+			return fmt.Errorf("setgroups: %v", errno)
+		}
+	}
+	_, _, errno := syscall.RawSyscall(sysSetgid, uintptr(cred.Gid), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("setgid %d: %v", cred.Gid, errno)
+	}
+	_, _, errno = syscall.RawSyscall(sysSetuid, uintptr(cred.Uid), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("setuid %d: %v", cred.Uid, errno)
+	}
+	return nil
+}
+
+// 声明了资源限制或沙箱时修改启动命令，先启动初始化进程，宿主设置资源限制后调用Resume执行应用
+// Return: 初始化进程，未声明资源限制及沙箱时返回nil
+func initCommand(name string, cmd *exec.Cmd, info *installer.ZipPackageInfo) (*initProcess, error) {
+	if info.Limits == nil && info.Sandbox == nil {
+		return nil, nil
+	}
+	if !initEnabled {
+		return nil, errors.New("Package: " + name + " resource limits and sandbox require supervisor.Init() to be called at the beginning of main")
+	}
+	conf := initConfig{
+		Path: cmd.Path,
+		Args: cmd.Args,
+	}
+	if info.Sandbox != nil {
+		err := sandboxCommand(name, cmd, info.Sandbox)
+		if err != nil {
+			return nil, err
+		}
+		conf.SandboxRoot = cmd.Dir
+	}
+	// 由初始化进程在挂载及设置资源限制后切换用户
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+		conf.Credential = cmd.SysProcAttr.Credential
+		cmd.SysProcAttr.Credential = nil
+	}
+
+	ret := &initProcess{name: name}
+	resumeR, resumeW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		resumeR.Close()
+		resumeW.Close()
+		return nil, err
+	}
+	ret.resume, ret.status = resumeW, statusR
+	ret.child = []*os.File{resumeR, statusW}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, resumeR, statusW)
+	conf.ResumeFd = 2 + len(cmd.ExtraFiles) - 1
+	conf.StatusFd = 2 + len(cmd.ExtraFiles)
+
+	d, err := json.Marshal(conf)
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = []string{"magnet-init"}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, initEnv+"="+string(d))
+	return ret, nil
+}
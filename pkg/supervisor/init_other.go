@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+	"os/exec"
+)
+
+// 非Linux系统不使用初始化进程
+func runInit() {}
+
+// 非Linux系统不支持资源限制及沙箱，直接执行应用
+func initCommand(name string, cmd *exec.Cmd, info *installer.ZipPackageInfo) (*initProcess, error) {
+	if info.Sandbox != nil {
+		return nil, sandboxCommand(name, cmd, info.Sandbox)
+	}
+	return nil, nil
+}
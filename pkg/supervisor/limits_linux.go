@@ -0,0 +1,143 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// cgroup v2 cpu.max的周期（微秒）
+	cgroupCPUPeriod = 100000
+)
+
+// 对已启动的初始化进程应用资源限制，初始化进程执行应用后限制仍然有效，返回创建的cgroup目录，未使用cgroup时返回空字符串
+func (s *ProcessSupervisor) applyLimits(name string, pid int, limits *installer.ResourceLimits) (string, error) {
+	if limits == nil {
+		return "", nil
+	}
+	cgroup := ""
+	if s.cgroupParent != "" {
+		dir, err := joinCgroup(s.cgroupParent, name, pid, limits)
+		if err != nil {
+			s.log.Warnf("Package: %s cgroup v2 not available, fallback to rlimit: %v\n", name, err)
+		} else {
+			cgroup = dir
+		}
+	}
+
+	if limits.NoFile > 0 {
+		err := prlimit(pid, syscall.RLIMIT_NOFILE, limits.NoFile)
+		if err != nil {
+			return cgroup, err
+		}
+	}
+	if cgroup == "" {
+		if limits.Memory > 0 {
+			err := prlimit(pid, syscall.RLIMIT_AS, uint64(limits.Memory))
+			if err != nil {
+				return cgroup, err
+			}
+		}
+		// RLIMIT_NPROC按用户统计且对root无效，无法限制单个应用的进程数
+		if limits.Pids > 0 {
+			return cgroup, errors.New("Package: " + name + " pids limit requires cgroup v2")
+		}
+		if limits.CPU > 0 {
+			s.log.Warnf("Package: %s cpu limit requires cgroup v2, ignored\n", name)
+		}
+	}
+	return cgroup, nil
+}
+
+func prlimit(pid int, resource int, value uint64) error {
+	limit := syscall.Rlimit{
+		Cur: value,
+		Max: value,
+	}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("prlimit resource %d error: %v ", resource, errno)
+	}
+	return nil
+}
+
+// 在parent下创建应用的cgroup，写入限制并将进程加入该cgroup
+func joinCgroup(parent, name string, pid int, limits *installer.ResourceLimits) (string, error) {
+	if _, err := os.Stat(filepath.Join(filepath.Dir(parent), "cgroup.controllers")); err != nil {
+		return "", errors.New("cgroup v2 hierarchy not found: " + filepath.Dir(parent))
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	// 在parent中启用需要的控制器，已启用时写入不会报错
+	var controllers []string
+	if limits.CPU > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if limits.Memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if limits.Pids > 0 {
+		controllers = append(controllers, "+pids")
+	}
+	for _, c := range controllers {
+		if err := writeCgroup(parent, "cgroup.subtree_control", c); err != nil {
+			return "", err
+		}
+	}
+
+	dir := filepath.Join(parent, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	err := setCgroup(dir, pid, limits)
+	if err != nil {
+		// 进程加入失败时cgroup中没有进程，可以直接删除
+		removeCgroup(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// 写入限制并将进程加入cgroup
+func setCgroup(dir string, pid int, limits *installer.ResourceLimits) error {
+	if limits.Memory > 0 {
+		if err := writeCgroup(dir, "memory.max", strconv.FormatInt(int64(limits.Memory), 10)); err != nil {
+			return err
+		}
+	}
+	if limits.Pids > 0 {
+		if err := writeCgroup(dir, "pids.max", strconv.FormatInt(limits.Pids, 10)); err != nil {
+			return err
+		}
+	}
+	if limits.CPU > 0 {
+		quota := int64(limits.CPU * cgroupCPUPeriod)
+		if err := writeCgroup(dir, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)); err != nil {
+			return err
+		}
+	}
+	return writeCgroup(dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+func writeCgroup(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
+
+// 删除应用的cgroup，cgroup中没有进程时才能删除
+func removeCgroup(dir string) error {
+	return os.Remove(dir)
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRlimit(t *testing.T) {
+	// 应用及其子进程启动时即受限制
+	pkg := createPackage(t, "ulimit -n\nsh -c 'ulimit -n'\n")
+	pkg.PkgInfo.Limits = &installer.ResourceLimits{
+		NoFile: 64,
+	}
+
+	s := NewSupervisor(SetCgroupParent(""))
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	r, err := s.Logs(pkg, time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	d, _ := ioutil.ReadAll(r)
+	if !strings.Contains(string(d), "\n64\n64\n") {
+		t.Fatal("expect nofile 64, got ", string(d))
+	}
+}
+
+func TestRlimitRunAsUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("run as user requires root")
+	}
+	pkg := createPackage(t, "id -u\nulimit -n\n")
+	os.Chmod(pkg.InstallPath, 0755)
+	pkg.PkgInfo.User = "nobody"
+	pkg.PkgInfo.Limits = &installer.ResourceLimits{
+		NoFile: 64,
+	}
+
+	s := NewSupervisor(SetCgroupParent(""), SetUserPolicy(installer.NewUserPolicy([]string{"nobody"}, nil)))
+	defer s.Close()
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	spec, _ := s.ExecSpec(pkg)
+	r, err := s.Logs(pkg, time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	d, _ := ioutil.ReadAll(r)
+	if !strings.Contains(string(d), "\n"+strconv.Itoa(spec.Uid)+"\n64\n") {
+		t.Fatal("expect run as nobody with nofile 64, got ", string(d))
+	}
+}
+
+// 宿主未调用Init时拒绝启动声明了资源限制的应用
+func TestRlimitWithoutInit(t *testing.T) {
+	initEnabled = false
+	defer func() { initEnabled = true }()
+	pkg := createPackage(t, "ulimit -n\n")
+	pkg.PkgInfo.Limits = &installer.ResourceLimits{
+		NoFile: 64,
+	}
+
+	s := NewSupervisor(SetCgroupParent(""))
+	defer s.Close()
+	err := s.Start(pkg)
+	if err == nil {
+		t.Fatal("expect Init required")
+	}
+	t.Log(err)
+}
+
+// 没有cgroup v2时无法限制进程数
+func TestPidsLimitWithoutCgroup(t *testing.T) {
+	pkg := createPackage(t, "sleep 10\n")
+	pkg.PkgInfo.Limits = &installer.ResourceLimits{
+		Pids: 10,
+	}
+
+	s := NewSupervisor(SetCgroupParent(""))
+	defer s.Close()
+	err := s.Start(pkg)
+	if err == nil || !strings.Contains(err.Error(), "cgroup v2") {
+		t.Fatal("expect pids limit requires cgroup v2, got ", err)
+	}
+	st, _ := s.Status("test")
+	if st.State == StateRunning {
+		t.Fatal("expect not running")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+)
+
+func (s *ProcessSupervisor) applyLimits(name string, pid int, limits *installer.ResourceLimits) (string, error) {
+	if limits != nil {
+		s.log.Warnf("Package: %s resource limits only support linux, ignored\n", name)
+	}
+	return "", nil
+}
+
+func removeCgroup(dir string) error {
+	return nil
+}
@@ -0,0 +1,478 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 应用输出日志文件名，轮转后的文件为output.log.1、output.log.2...，序号越大越旧
+	// 应用的标准输出及标准错误直接写入日志文件，宿主退出后应用仍可继续输出
+	// 应用启动、退出及日志轮转时宿主写入记录行，格式为：时间 magnet 内容
+	LogFileName = "output.log"
+
+	// 默认单个日志文件最大大小
+	DefaultLogMaxSize = 10 * 1024 * 1024
+	// 默认保留的轮转日志文件个数
+	DefaultLogMaxBackups = 5
+
+	// 记录行时间格式
+	logTimeFormat = time.RFC3339Nano
+	// 记录行的输出流名称
+	logStream = "magnet"
+	// follow模式下检查日志更新的间隔
+	logPollInterval = 200 * time.Millisecond
+	// 检查日志文件大小的间隔
+	logRotateInterval = time.Second
+)
+
+// 获得应用的日志目录，位于安装目录旁
+func LogDir(installPath string) string {
+	return filepath.Clean(installPath) + ".logs"
+}
+
+// 按大小轮转的日志文件
+// 应用直接持有日志文件的描述符，宿主定时检查文件大小，超过最大大小时复制后截断
+type rotateWriter struct {
+	dir        string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	lock sync.Mutex
+	stop chan struct{}
+	once sync.Once
+}
+
+func newRotateWriter(dir string, maxSize int64, maxBackups int) (*rotateWriter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	ret := &rotateWriter{
+		dir:        dir,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		stop:       make(chan struct{}),
+	}
+	err = ret.open()
+	if err != nil {
+		return nil, err
+	}
+	go ret.run()
+	return ret, nil
+}
+
+func (w *rotateWriter) open() error {
+	f, err := os.OpenFile(filepath.Join(w.dir, LogFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	return nil
+}
+
+// 应用的标准输出及标准错误，轮转时不会重新打开
+func (w *rotateWriter) File() *os.File {
+	return w.file
+}
+
+func (w *rotateWriter) run() {
+	ticker := time.NewTicker(logRotateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.lock.Lock()
+			w.checkRotate()
+			w.lock.Unlock()
+		}
+	}
+}
+
+// 超过最大大小则轮转，调用时需持有锁
+func (w *rotateWriter) checkRotate() error {
+	if w.maxSize <= 0 {
+		return nil
+	}
+	fi, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() <= w.maxSize {
+		return nil
+	}
+	return w.rotate()
+}
+
+// 复制当前日志文件后截断，应用以O_APPEND写入，截断后从文件开头继续写入
+// 复制与截断之间应用写入的内容将丢失
+func (w *rotateWriter) rotate() error {
+	name := filepath.Join(w.dir, LogFileName)
+	if w.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", name, w.maxBackups))
+		for i := w.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", name, i), fmt.Sprintf("%s.%d", name, i+1))
+		}
+		err := copyLog(name, name+".1")
+		if err != nil {
+			return err
+		}
+	}
+	err := w.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	// 之后的内容均在轮转时间之后写入
+	return w.writeMark("log rotated")
+}
+
+func copyLog(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// 写入带时间的记录行，用于按时间过滤日志
+func (w *rotateWriter) Mark(msg string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.writeMark(msg)
+}
+
+// 调用时需持有锁
+func (w *rotateWriter) writeMark(msg string) error {
+	var b bytes.Buffer
+	// 应用的输出可能未以换行结束
+	if fi, err := w.file.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := w.file.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteString(time.Now().Format(logTimeFormat))
+	b.WriteByte(' ')
+	b.WriteString(logStream)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	b.WriteByte('\n')
+	_, err := w.file.Write(b.Bytes())
+	return err
+}
+
+func (w *rotateWriter) Close() error {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.file.Close()
+}
+
+// 打开应用日志
+// Param: dir日志目录，since只返回该时间之后的日志（按宿主写入的记录行时间判断），零值返回所有日志，follow为true则持续输出新增日志直到关闭
+// Return: 日志读取器，使用完毕后需要关闭
+func OpenLogs(dir string, since time.Time, follow bool) (io.ReadCloser, error) {
+	name := filepath.Join(dir, LogFileName)
+	if _, err := os.Stat(name); err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	t := &logTailer{
+		name:  name,
+		since: since,
+		w:     w,
+		stop:  make(chan struct{}),
+	}
+	go t.run(follow)
+	return &logReader{PipeReader: r, tailer: t}, nil
+}
+
+type logReader struct {
+	*io.PipeReader
+	tailer *logTailer
+	once   sync.Once
+}
+
+func (r *logReader) Close() error {
+	r.once.Do(func() {
+		close(r.tailer.stop)
+	})
+	return r.PipeReader.Close()
+}
+
+type logTailer struct {
+	name  string
+	since time.Time
+	w     *io.PipeWriter
+	stop  chan struct{}
+}
+
+func (t *logTailer) run(follow bool) {
+	var err error
+	defer func() {
+		t.w.CloseWithError(err)
+	}()
+
+	// 由旧到新
+	var files []string
+	for i := lastBackup(t.name); i > 0; i-- {
+		files = append(files, fmt.Sprintf("%s.%d", t.name, i))
+	}
+	files = append(files, t.name)
+	start, offset := 0, int64(0)
+	if !t.since.IsZero() {
+		start, offset, err = t.findStart(files)
+		if err != nil {
+			return
+		}
+	}
+	last := len(files) - 1
+	for i := start; i < last; i++ {
+		err = t.copyFile(files[i], offset)
+		if err != nil {
+			return
+		}
+		offset = 0
+	}
+	if !follow {
+		err = t.copyFile(t.name, offset)
+		return
+	}
+	err = t.follow(offset)
+}
+
+// 应用的输出行没有时间，只能按宿主写入的记录行过滤：跳过最后一条早于since的记录行及之前的内容
+// Return: 开始读取的文件序号及位置
+func (t *logTailer) findStart(files []string) (int, int64, error) {
+	start, offset := 0, int64(0)
+	for i, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, 0, err
+		}
+		var pos int64
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadString('\n')
+			pos += int64(len(line))
+			if ts, ok := parseMark(line); ok {
+				// 记录行的时间递增
+				if !ts.Before(t.since) {
+					f.Close()
+					return start, offset, nil
+				}
+				start, offset = i, pos
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return 0, 0, err
+			}
+		}
+		f.Close()
+	}
+	return start, offset, nil
+}
+
+// 解析记录行的时间，记录行格式为：时间 magnet 内容
+func parseMark(line string) (time.Time, bool) {
+	i := strings.IndexByte(line, ' ')
+	if i <= 0 || !strings.HasPrefix(line[i+1:], logStream+" ") {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(logTimeFormat, line[:i])
+	return ts, err == nil
+}
+
+// 获得已存在的最大轮转序号
+func lastBackup(name string) int {
+	ret := 0
+	files, _ := filepath.Glob(name + ".*")
+	for _, f := range files {
+		var i int
+		_, err := fmt.Sscanf(strings.TrimPrefix(f, name+"."), "%d", &i)
+		if err == nil && i > ret {
+			ret = i
+		}
+	}
+	return ret
+}
+
+func (t *logTailer) copyFile(name string, offset int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			if werr := t.writeLine(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *logTailer) follow(offset int64) error {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	var r *bufio.Reader
+	var partial string
+	// 当前文件已读取的长度，用于识别复制后截断的轮转
+	var pos int64
+	for {
+		if f == nil {
+			var err error
+			f, err = os.Open(t.name)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if f != nil {
+				pos, err = f.Seek(offset, io.SeekStart)
+				if err != nil {
+					return err
+				}
+				r = bufio.NewReader(f)
+				offset = 0
+			}
+		}
+		if f != nil {
+			n, err := t.readLines(r, &partial)
+			pos += n
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-t.stop:
+			return nil
+		case <-time.After(logPollInterval):
+		}
+
+		if f == nil {
+			continue
+		}
+		cur, err1 := f.Stat()
+		fi, err2 := os.Stat(t.name)
+		switch {
+		case err1 != nil || err2 != nil || !os.SameFile(cur, fi):
+			// 日志文件已被替换，读取剩余的内容后重新打开
+			if err1 == nil {
+				_, err := t.readLines(r, &partial)
+				if err != nil {
+					return err
+				}
+			}
+			f.Close()
+			f = nil
+		case cur.Size() < pos:
+			// 日志文件复制后被截断，从轮转的文件中读取截断前未读取的内容
+			err := t.readBackup(t.name+".1", pos, &partial)
+			if err != nil {
+				return err
+			}
+			_, err = f.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+			r.Reset(f)
+			pos = 0
+		}
+	}
+}
+
+// 读取完整的行，未以换行结束的内容保存在partial中
+// Return: 读取的长度
+func (t *logTailer) readLines(r *bufio.Reader, partial *string) (int64, error) {
+	var n int64
+	for {
+		line, err := r.ReadString('\n')
+		n += int64(len(line))
+		if err == nil {
+			if werr := t.writeLine(*partial + line); werr != nil {
+				return n, werr
+			}
+			*partial = ""
+			continue
+		}
+		*partial += line
+		if err == io.EOF {
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (t *logTailer) readBackup(name string, offset int64, partial *string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = t.readLines(bufio.NewReader(f), partial)
+	return err
+}
+
+func (t *logTailer) writeLine(line string) error {
+	_, err := io.WriteString(t.w, line)
+	return err
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"bufio"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogs(t *testing.T) {
+	pkg := createPackage(t, "echo hello\necho world >&2\nprintf partial\n")
+
+	s := NewSupervisor()
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	r, err := s.Logs(pkg, time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 启动记录、应用的3行输出及退出记录
+	lines := strings.Split(strings.TrimSpace(string(d)), "\n")
+	if len(lines) != 5 {
+		t.Fatal("expect 5 lines, got ", string(d))
+	}
+	for _, v := range []string{" magnet start\n", "\nhello\n", "\nworld\n", "\npartial\n", " magnet exit pid "} {
+		if !strings.Contains(string(d), v) {
+			t.Fatal("unexpected logs: ", string(d))
+		}
+	}
+
+	r2, err := s.Logs(pkg, time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	d, _ = ioutil.ReadAll(r2)
+	if len(d) != 0 {
+		t.Fatal("expect no logs since now, got ", string(d))
+	}
+}
+
+func TestLogsRotateFollow(t *testing.T) {
+	pkg := createPackage(t, "i=0\nwhile [ $i -lt 20 ]; do echo line$i; i=$((i+1)); sleep 0.05; done\n")
+
+	s := NewSupervisor(SetLogRotate(64, 10))
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Logs(pkg, time.Time{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	for i := 0; i < 20; {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := parseMark(line); ok {
+			continue
+		}
+		if line != "line"+strconv.Itoa(i)+"\n" {
+			t.Fatal("unexpected line: ", line)
+		}
+		i++
+	}
+	if lastBackup(LogDir(pkg.InstallPath)+"/"+LogFileName) == 0 {
+		t.Fatal("expect log rotated")
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const credentialSupported = true
+
+// 应用进程使用独立的进程组，停止时向整个进程组发送信号，避免子进程残留
+func setProcAttr(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func killProcess(pid int) error {
+	return signalProcess(pid, syscall.SIGKILL)
+}
+
+func signalProcess(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if err != nil {
+		return syscall.Kill(pid, sig)
+	}
+	return nil
+}
+
+// 设置运行进程的用户及用户组，为-1时使用当前用户或用户组
+func setCredential(cmd *exec.Cmd, uid, gid int) {
+	if uid < 0 {
+		uid = os.Getuid()
+	}
+	if gid < 0 {
+		gid = os.Getgid()
+	}
+	if uid == os.Getuid() && gid == os.Getgid() {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}
+}
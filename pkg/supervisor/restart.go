@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+	"github.com/xfali/xlog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认的文件更新防抖时间
+	DefaultRestartDebounce = time.Second
+)
+
+const (
+	actionNone = iota
+	actionReload
+	actionRestart
+)
+
+// 文件更新时重启应用的监听器，实现watcher.PackageListener
+// 可执行文件更新时重启应用，配置文件（configFiles）更新时发送reloadSignal，未声明reloadSignal则重启应用
+// 在防抖时间内的多次更新只会触发一次重启，仅对正在运行的应用生效
+type RestartListener struct {
+	supervisor Supervisor
+	debounce   time.Duration
+	log        xlog.Logger
+
+	pending map[string]int
+	timers  map[string]*time.Timer
+	lock    sync.Mutex
+}
+
+func NewRestartListener(s Supervisor, debounce time.Duration) *RestartListener {
+	if debounce <= 0 {
+		debounce = DefaultRestartDebounce
+	}
+	return &RestartListener{
+		supervisor: s,
+		debounce:   debounce,
+		log:        xlog.GetLogger(),
+		pending:    map[string]int{},
+		timers:     map[string]*time.Timer{},
+	}
+}
+
+func (l *RestartListener) OnUpdate(p installer.Package, filename string) {
+	info, err := execInfo(p)
+	if err != nil {
+		return
+	}
+	action := matchAction(p, info, filename)
+	if action == actionNone {
+		return
+	}
+
+	name := p.GetName()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if action > l.pending[name] {
+		l.pending[name] = action
+	}
+	if t, ok := l.timers[name]; ok {
+		t.Reset(l.debounce)
+		return
+	}
+	l.timers[name] = time.AfterFunc(l.debounce, func() {
+		l.fire(name, info)
+	})
+}
+
+func (l *RestartListener) OnRemove(p installer.Package, filename string) {
+}
+
+func (l *RestartListener) fire(name string, info *installer.ZipPackageInfo) {
+	l.lock.Lock()
+	action := l.pending[name]
+	delete(l.pending, name)
+	delete(l.timers, name)
+	l.lock.Unlock()
+
+	st, err := l.supervisor.Status(name)
+	if err != nil || st.State != StateRunning {
+		return
+	}
+	if action == actionReload {
+		sig, err := ParseSignal(info.ReloadSignal)
+		if err == nil {
+			l.log.Infof("Package: %s config changed, send %v\n", name, sig)
+			err = l.supervisor.Signal(name, sig)
+		}
+		if err != nil {
+			l.log.Errorf("Package: %s reload error: %v\n", name, err)
+		}
+		return
+	}
+	l.log.Infof("Package: %s changed, restart\n", name)
+	err = l.supervisor.Restart(name)
+	if err != nil {
+		l.log.Errorf("Package: %s restart error: %v\n", name, err)
+	}
+}
+
+func matchAction(p installer.Package, info *installer.ZipPackageInfo, filename string) int {
+	filename = filepath.Clean(filename)
+	if info.ExecName != "" && filename == filepath.Join(p.GetInstallPath(), info.ExecName) {
+		return actionRestart
+	}
+	for _, f := range info.ConfigFiles {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(p.GetInstallPath(), f)
+		}
+		if filename == filepath.Clean(f) {
+			if info.ReloadSignal != "" {
+				return actionReload
+			}
+			return actionRestart
+		}
+	}
+	return actionNone
+}
@@ -0,0 +1,46 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestartListener(t *testing.T) {
+	pkg := createPackage(t, "trap 'echo reload' HUP\nwhile true; do sleep 0.1; done\n")
+	pkg.PkgInfo.ConfigFiles = []string{"app.conf"}
+	pkg.PkgInfo.ReloadSignal = "SIGHUP"
+
+	s := NewSupervisor()
+	defer s.Close()
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := s.Status("test")
+	pid := st.Pid
+
+	l := NewRestartListener(s, 100*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		l.OnUpdate(pkg, filepath.Join(pkg.InstallPath, "app.conf"))
+	}
+	time.Sleep(500 * time.Millisecond)
+	st, _ = s.Status("test")
+	if st.Pid != pid || st.State != StateRunning {
+		t.Fatal("expect reload without restart")
+	}
+
+	l.OnUpdate(pkg, filepath.Join(pkg.InstallPath, "other"))
+	l.OnUpdate(pkg, filepath.Join(pkg.InstallPath, "run.sh"))
+	l.OnUpdate(pkg, filepath.Join(pkg.InstallPath, "app.conf"))
+	time.Sleep(time.Second)
+	st, _ = s.Status("test")
+	if st.Pid == pid || st.State != StateRunning {
+		t.Fatal("expect restarted")
+	}
+}
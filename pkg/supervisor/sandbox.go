@@ -0,0 +1,21 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+// 安装包声明了沙箱但当前系统无法创建沙箱时返回的错误，如非Linux系统或者不支持非特权用户命名空间
+type SandboxError struct {
+	// 安装包名称
+	Name string
+	Err  error
+}
+
+func (e *SandboxError) Error() string {
+	return "Package: " + e.Name + " sandbox unavailable: " + e.Err.Error()
+}
+
+func (e *SandboxError) Unwrap() error {
+	return e.Err
+}
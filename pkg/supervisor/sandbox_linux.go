@@ -0,0 +1,95 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+const (
+	// 重新挂载为只读时需保留的原挂载标志，用户命名空间内不允许清除这些标志
+	keepMountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+		syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+)
+
+// 在新的命名空间中将root只读挂载并挂载proc，由初始化进程调用，应用在pid命名空间中为1号进程
+func sandboxMount(root string) error {
+	// 挂载事件不传播到宿主的命名空间
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("make mount private: %v", err)
+	}
+	err = syscall.Mount(root, root, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("bind %s: %v", root, err)
+	}
+	var st syscall.Statfs_t
+	err = syscall.Statfs(root, &st)
+	if err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | uintptr(st.Flags)&keepMountFlags
+	err = syscall.Mount("", root, "", flags, "")
+	if err != nil {
+		return fmt.Errorf("remount %s read-only: %v", root, err)
+	}
+	err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		return fmt.Errorf("mount proc: %v", err)
+	}
+	// 工作目录仍指向挂载前的目录，重新进入只读挂载的目录
+	return os.Chdir(root)
+}
+
+// 设置初始化进程的命名空间，初始化进程在新的命名空间中完成挂载后执行应用
+func sandboxCommand(name string, cmd *exec.Cmd, sandbox *installer.Sandbox) error {
+	rootless := os.Geteuid() != 0
+	if rootless {
+		err := checkUserNamespace()
+		if err != nil {
+			return &SandboxError{Name: name, Err: err}
+		}
+		if cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+			return &SandboxError{Name: name, Err: errors.New("run as user requires root")}
+		}
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if sandbox.NoNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if rootless {
+		// 命名空间内的root映射为宿主的当前用户，挂载需要命名空间内的root权限
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	}
+	return nil
+}
+
+// 检查系统是否允许非特权用户创建用户命名空间
+func checkUserNamespace() error {
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		return errors.New("user namespace is not supported by kernel")
+	}
+	if d, err := ioutil.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil && strings.TrimSpace(string(d)) == "0" {
+		return errors.New("user namespace is disabled (user.max_user_namespaces = 0)")
+	}
+	if d, err := ioutil.ReadFile("/proc/sys/kernel/unprivileged_userns_clone"); err == nil && strings.TrimSpace(string(d)) == "0" {
+		return errors.New("unprivileged user namespace is disabled (kernel.unprivileged_userns_clone = 0)")
+	}
+	return nil
+}
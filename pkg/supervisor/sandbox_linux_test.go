@@ -0,0 +1,100 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSandbox(t *testing.T) {
+	pkg := createPackage(t, "touch x 2>/dev/null && echo writable || echo readonly\necho pid $$\n")
+	pkg.PkgInfo.Sandbox = &installer.Sandbox{NoNetwork: true}
+
+	s := NewSupervisor()
+	defer s.Close()
+	err := s.Start(pkg)
+	var serr *SandboxError
+	if errors.As(err, &serr) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	r, err := s.Logs(pkg, time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	d, _ := ioutil.ReadAll(r)
+	out := string(d)
+	if !strings.Contains(out, "\nreadonly\n") || !strings.Contains(out, "\npid 1\n") {
+		t.Fatal("expect run in sandbox, got ", out)
+	}
+}
+
+// 以root运行时，沙箱初始化进程完成挂载后再切换为安装包声明的用户
+func TestSandboxRunAsUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("run as user requires root")
+	}
+	pkg := createPackage(t, "id -u\n")
+	os.Chmod(pkg.InstallPath, 0755)
+	pkg.PkgInfo.User = "nobody"
+	pkg.PkgInfo.Sandbox = &installer.Sandbox{}
+
+	s := NewSupervisor(SetUserPolicy(installer.NewUserPolicy([]string{"nobody"}, nil)))
+	defer s.Close()
+	err := s.Start(pkg)
+	var serr *SandboxError
+	if errors.As(err, &serr) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	spec, _ := s.ExecSpec(pkg)
+	r, err := s.Logs(pkg, time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	d, _ := ioutil.ReadAll(r)
+	if !strings.Contains(string(d), "\n"+strconv.Itoa(spec.Uid)+"\n") {
+		t.Fatal("expect run as nobody in sandbox, got ", string(d))
+	}
+}
+
+// 沙箱初始化进程执行应用失败时Start返回错误
+func TestSandboxSetupFailed(t *testing.T) {
+	pkg := createPackage(t, "")
+	pkg.PkgInfo.ExecCmd = "/nonexistent/app"
+	pkg.PkgInfo.Sandbox = &installer.Sandbox{}
+
+	s := NewSupervisor()
+	defer s.Close()
+	err := s.Start(pkg)
+	var serr *SandboxError
+	if errors.As(err, &serr) {
+		t.Skip(err)
+	}
+	if err == nil {
+		t.Fatal("expect sandbox setup failed")
+	}
+	t.Log(err)
+	st, _ := s.Status("test")
+	if st.State == StateRunning {
+		t.Fatal("expect not running")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"os/exec"
+)
+
+func sandboxCommand(name string, cmd *exec.Cmd, sandbox *installer.Sandbox) error {
+	return &SandboxError{Name: name, Err: errors.New("sandbox only support linux")}
+}
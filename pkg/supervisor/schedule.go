@@ -0,0 +1,153 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+	"github.com/robfig/cron/v3"
+	"github.com/xfali/magnet/pkg/installer"
+	"time"
+)
+
+const (
+	// 每个定时任务保留的运行记录数
+	MaxRunHistory = 100
+)
+
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// 定时任务的一次运行记录
+type Run struct {
+	// 启动时间
+	StartTime time.Time
+	// 结束时间
+	EndTime time.Time
+	// 退出码
+	ExitCode int
+	// 未能运行的原因，如启动失败或者上次运行尚未结束
+	Error string
+}
+
+type schedule struct {
+	cron     cron.Schedule
+	location *time.Location
+}
+
+// 解析定时任务计划
+func parseSchedule(s *installer.Schedule) (*schedule, error) {
+	if s.Cron == "" {
+		return nil, errors.New("Schedule cron is empty ")
+	}
+	loc := time.Local
+	if s.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c, err := cronParser.Parse(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule{
+		cron:     c,
+		location: loc,
+	}, nil
+}
+
+// 获得t之后的下次运行时间
+func (s *schedule) Next(t time.Time) time.Time {
+	return s.cron.Next(t.In(s.location))
+}
+
+// 启动定时任务，调用时需持有锁
+func (s *ProcessSupervisor) startSchedule(p *process) error {
+	sched, err := parseSchedule(p.info.Schedule)
+	if err != nil {
+		return err
+	}
+	p.sched = sched
+	p.status.State = StateScheduled
+	s.scheduleNext(p)
+	return nil
+}
+
+// 设置下次运行的定时器，调用时需持有锁
+func (s *ProcessSupervisor) scheduleNext(p *process) {
+	now := time.Now()
+	next := p.sched.Next(now)
+	if next.IsZero() {
+		s.log.Warnf("Package: %s schedule has no next run\n", p.status.Name)
+		return
+	}
+	p.timer = time.AfterFunc(next.Sub(now), func() {
+		s.runSchedule(p)
+	})
+}
+
+func (s *ProcessSupervisor) runSchedule(p *process) {
+	s.lock.Lock()
+	switch p.status.State {
+	case StateScheduled:
+	case StateRunning:
+		// 上次运行尚未结束，跳过本次运行
+		s.log.Warnf("Package: %s is still running, skip scheduled run\n", p.status.Name)
+		p.addRun(Run{
+			StartTime: time.Now(),
+			EndTime:   time.Now(),
+			ExitCode:  -1,
+			Error:     "previous run is still running",
+		})
+		s.scheduleNext(p)
+		s.lock.Unlock()
+		return
+	default:
+		s.lock.Unlock()
+		return
+	}
+
+	err := s.spawn(p)
+	if err != nil {
+		s.log.Errorf("Package: %s scheduled run error: %v\n", p.status.Name, err)
+		p.addRun(Run{
+			StartTime: time.Now(),
+			EndTime:   time.Now(),
+			ExitCode:  -1,
+			Error:     err.Error(),
+		})
+	}
+	s.scheduleNext(p)
+	pid := p.status.Pid
+	s.lock.Unlock()
+
+	if err == nil {
+		for _, l := range s.getListeners() {
+			l.OnStart(p.pkg, pid)
+		}
+	}
+}
+
+func (s *ProcessSupervisor) Runs(name string) ([]Run, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p, ok := s.procs[name]
+	if !ok {
+		return nil, errors.New("Package: " + name + " not found ")
+	}
+	ret := make([]Run, len(p.runs))
+	copy(ret, p.runs)
+	return ret, nil
+}
+
+// 添加运行记录，调用时需持有锁
+func (p *process) addRun(r Run) {
+	p.runs = append(p.runs, r)
+	if len(p.runs) > MaxRunHistory {
+		p.runs = p.runs[len(p.runs)-MaxRunHistory:]
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	pkg := createPackage(t, "sleep 1.5\nexit 4\n")
+	pkg.PkgInfo.Schedule = &installer.Schedule{
+		Cron:     "* * * * * *",
+		Timezone: "UTC",
+	}
+
+	s := NewSupervisor()
+	defer s.Close()
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := s.Status("test")
+	if st.State != StateScheduled {
+		t.Fatal("expect scheduled, got ", st.State)
+	}
+	time.Sleep(3500 * time.Millisecond)
+	err = s.Stop("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := s.Runs("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished, skipped := 0, 0
+	for _, r := range runs {
+		t.Log(r)
+		if r.Error != "" {
+			skipped++
+		} else if r.ExitCode == 4 {
+			finished++
+		}
+	}
+	if finished == 0 || skipped == 0 {
+		t.Fatal("expect finished and skipped runs")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	s, err := parseSchedule(&installer.Schedule{Cron: "0 30 2 * * *", Timezone: "Asia/Shanghai"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	next := s.Next(time.Date(2020, 1, 1, 0, 0, 0, 0, loc))
+	if !next.Equal(time.Date(2020, 1, 1, 2, 30, 0, 0, loc)) {
+		t.Fatal("unexpected next: ", next)
+	}
+
+	_, err = parseSchedule(&installer.Schedule{Cron: "bad"})
+	if err == nil {
+		t.Fatal("expect parse error")
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+//go:build linux && !386 && !arm
+// +build linux,!386,!arm
+
+package supervisor
+
+import (
+	"syscall"
+)
+
+const (
+	sysSetgroups = syscall.SYS_SETGROUPS
+	sysSetgid    = syscall.SYS_SETGID
+	sysSetuid    = syscall.SYS_SETUID
+)
@@ -0,0 +1,21 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+//go:build linux && (386 || arm)
+// +build linux
+// +build 386 arm
+
+package supervisor
+
+import (
+	"syscall"
+)
+
+// 32位系统中的setuid等系统调用只支持16位的id
+const (
+	sysSetgroups = syscall.SYS_SETGROUPS32
+	sysSetgid    = syscall.SYS_SETGID32
+	sysSetuid    = syscall.SYS_SETUID32
+)
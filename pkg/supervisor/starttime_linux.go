@@ -0,0 +1,32 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// 获得进程的启动时间（系统启动后的时钟周期数），用于识别pid是否被复用
+func processStartTime(pid int) (uint64, error) {
+	d, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	// 进程名可能包含空格及括号，从最后一个')'之后开始解析，starttime为第22个字段
+	stat := string(d)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, errors.New("Invalid stat of pid: " + strconv.Itoa(pid))
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return 0, errors.New("Invalid stat of pid: " + strconv.Itoa(pid))
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
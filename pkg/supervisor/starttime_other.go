@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"errors"
+)
+
+func processStartTime(pid int) (uint64, error) {
+	return 0, errors.New("Process start time only support linux ")
+}
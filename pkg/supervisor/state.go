@@ -0,0 +1,196 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/xfali/goutils/io"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// 检查接管的进程是否仍在运行的间隔
+	adoptPollInterval = time.Second
+)
+
+// 状态文件中记录的运行中的进程
+type processRecord struct {
+	Pid         int       `json:"pid"`
+	StartTicks  uint64    `json:"startTicks"`
+	StartTime   time.Time `json:"startTime"`
+	InstallPath string    `json:"installPath"`
+	Cmd         []string  `json:"cmd"`
+	Cgroup      string    `json:"cgroup,omitempty"`
+}
+
+// 将运行中的进程写入状态文件，调用时需持有锁
+func (s *ProcessSupervisor) saveState() {
+	if s.stateFile == "" {
+		return
+	}
+	records := map[string]processRecord{}
+	for name, p := range s.procs {
+		if p.status.State != StateRunning || p.startTicks == 0 {
+			continue
+		}
+		records[name] = processRecord{
+			Pid:         p.status.Pid,
+			StartTicks:  p.startTicks,
+			StartTime:   p.status.StartTime,
+			InstallPath: p.status.InstallPath,
+			Cmd:         p.status.Cmd,
+			Cgroup:      p.cgroup,
+		}
+	}
+	err := writeState(s.stateFile, records)
+	if err != nil {
+		s.log.Errorf("Save process state: %s error: %v\n", s.stateFile, err)
+	}
+}
+
+// 先写入临时文件再重命名，避免宿主异常退出时状态文件不完整
+func writeState(path string, records map[string]processRecord) error {
+	d, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, d, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readState(path string) (map[string]processRecord, error) {
+	ret := map[string]processRecord{}
+	if !io.IsPathExists(path) {
+		return ret, nil
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(d, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *ProcessSupervisor) Adopt(pkgs ...installer.Package) error {
+	if s.stateFile == "" {
+		return nil
+	}
+	records, err := readState(s.stateFile)
+	if err != nil {
+		return err
+	}
+
+	var adopted []*process
+	var pids []int
+	s.lock.Lock()
+	for _, pkg := range pkgs {
+		if pkg == nil {
+			continue
+		}
+		r, ok := records[pkg.GetName()]
+		if !ok || filepath.Clean(r.InstallPath) != filepath.Clean(pkg.GetInstallPath()) {
+			continue
+		}
+		if p, ok := s.procs[pkg.GetName()]; ok && p.active() {
+			continue
+		}
+		ticks, err := processStartTime(r.Pid)
+		if err != nil || ticks != r.StartTicks {
+			s.log.Infof("Package: %s pid: %d is not running, skip adopt\n", pkg.GetName(), r.Pid)
+			continue
+		}
+		p, err := s.adopt(pkg, r)
+		if err != nil {
+			s.log.Errorf("Adopt package: %s pid: %d error: %v\n", pkg.GetName(), r.Pid, err)
+			continue
+		}
+		s.procs[pkg.GetName()] = p
+		adopted = append(adopted, p)
+		pids = append(pids, p.status.Pid)
+	}
+	s.saveState()
+	s.lock.Unlock()
+
+	for i, p := range adopted {
+		for _, l := range s.getListeners() {
+			l.OnStart(p.pkg, pids[i])
+		}
+	}
+	return nil
+}
+
+// 接管仍在运行的进程，调用时需持有锁
+func (s *ProcessSupervisor) adopt(pkg installer.Package, r processRecord) (*process, error) {
+	info, err := execInfo(pkg)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := BuildSpec(pkg, info, s.env)
+	if err != nil {
+		return nil, err
+	}
+	p := &process{
+		pkg:  pkg,
+		info: info,
+		spec: spec,
+		status: Status{
+			Name:        pkg.GetName(),
+			InstallPath: pkg.GetInstallPath(),
+		},
+	}
+	if info.Schedule != nil {
+		// 定时任务在本次运行结束后继续按计划运行
+		err = s.startSchedule(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 接管的进程仍持有日志文件，继续按大小轮转
+	logs, err := newRotateWriter(LogDir(pkg.GetInstallPath()), s.logMaxSize, s.logBackups)
+	if err != nil {
+		p.stopTimer()
+		return nil, err
+	}
+	logs.Mark("adopt pid " + strconv.Itoa(r.Pid))
+	p.logs = logs
+	p.cgroup = r.Cgroup
+	p.startTicks = r.StartTicks
+	p.done = make(chan struct{})
+	p.status.Cmd = r.Cmd
+	p.status.State = StateRunning
+	p.status.Pid = r.Pid
+	p.status.StartTime = r.StartTime
+	s.log.Infof("Adopt package: %s pid: %d cmd: %v\n", p.status.Name, p.status.Pid, r.Cmd)
+
+	go s.poll(p, r.Pid, r.StartTicks, p.done)
+	return p, nil
+}
+
+// 接管的进程不是当前进程的子进程，无法等待其退出，定时检查进程是否仍在运行
+// 进程退出后无法获得退出码，退出码记为-1
+func (s *ProcessSupervisor) poll(p *process, pid int, startTicks uint64, done chan struct{}) {
+	for {
+		time.Sleep(adoptPollInterval)
+		ticks, err := processStartTime(pid)
+		if err != nil || ticks != startTicks {
+			s.exited(p, done, -1, errors.New("adopted process exited"))
+			return
+		}
+	}
+}
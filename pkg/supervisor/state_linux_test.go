@@ -0,0 +1,188 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const envTestHost = "MAGNET_TEST_HOST"
+
+// 作为宿主进程运行，启动应用后直接退出
+func TestHelperHost(t *testing.T) {
+	v := os.Getenv(envTestHost)
+	if v == "" {
+		return
+	}
+	args := strings.SplitN(v, string(os.PathListSeparator), 2)
+	s := NewSupervisor(SetStateFile(args[1]))
+	err := s.Start(hostPackage(args[0]))
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func hostPackage(dir string) *installer.ZipPackage {
+	return &installer.ZipPackage{
+		Name:        "test",
+		Version:     1,
+		InstallPath: dir,
+		PkgInfo: &installer.ZipPackageInfo{
+			Name:       "test",
+			AppVersion: 1,
+			ExecCmd:    "sh ${EXECUTABLE}",
+			ExecName:   "run.sh",
+		},
+	}
+}
+
+// 宿主进程退出后，持续输出的应用仍在运行并继续写入日志
+func TestAdoptHostExit(t *testing.T) {
+	pkg := createPackage(t, "while true; do echo tick; sleep 0.2; done\n")
+	stateDir, err := ioutil.TempDir("", "magnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(stateDir)
+	})
+	stateFile := filepath.Join(stateDir, "state.proc")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperHost$")
+	cmd.Env = append(os.Environ(), envTestHost+"="+pkg.InstallPath+string(os.PathListSeparator)+stateFile)
+	err = cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSupervisor(SetStateFile(stateFile))
+	defer s.Close()
+	err = s.Adopt(hostPackage(pkg.InstallPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile := filepath.Join(LogDir(pkg.InstallPath), LogFileName)
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * time.Second)
+	st, err := s.Status("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != StateRunning {
+		t.Fatal("expect adopted process running, got ", st.State, " exit code ", st.ExitCode)
+	}
+	fi2, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi2.Size() <= fi.Size() {
+		t.Fatal("expect output written after host exit")
+	}
+	err = s.Stop("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	pkg := createPackage(t, "sleep 10\n")
+	stateFile := filepath.Join(pkg.InstallPath, "state.proc")
+
+	s := NewSupervisor(SetStateFile(stateFile))
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := s.Status("test")
+
+	// 模拟宿主重启，使用新的Supervisor接管进程
+	s2 := NewSupervisor(SetStateFile(stateFile))
+	defer s2.Close()
+	err = s2.Adopt(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st2, err := s2.Status("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st2.State != StateRunning || st2.Pid != st.Pid {
+		t.Fatal("expect adopt pid ", st.Pid, " got ", st2.State, st2.Pid)
+	}
+	err = s2.Start(pkg)
+	if err == nil {
+		t.Fatal("expect start adopted package failed")
+	}
+
+	err = s2.Stop("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st2, _ = s2.Status("test")
+	if st2.State != StateStopped {
+		t.Fatal("expect stopped, got ", st2.State)
+	}
+	time.Sleep(100 * time.Millisecond)
+	st, _ = s.Status("test")
+	if st.State == StateRunning {
+		t.Fatal("expect process killed")
+	}
+
+	// 状态文件中已无运行中的进程
+	records, err := readState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatal("expect empty state, got ", records)
+	}
+}
+
+func TestAdoptPidReused(t *testing.T) {
+	pkg := createPackage(t, "sleep 10\n")
+	stateFile := filepath.Join(pkg.InstallPath, "state.proc")
+
+	s := NewSupervisor(SetStateFile(stateFile))
+	defer s.Close()
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := readState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := records["test"]
+	r.StartTicks++
+	records["test"] = r
+	err = writeState(stateFile, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := NewSupervisor(SetStateFile(stateFile))
+	defer s2.Close()
+	err = s2.Adopt(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s2.Status("test")
+	if err == nil {
+		t.Fatal("expect pid reused process not adopted")
+	}
+}
@@ -0,0 +1,728 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"github.com/xfali/magnet/pkg/watcher"
+	"github.com/xfali/xlog"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// 默认发送停止信号后等待进程退出的时间，超时则强制结束进程
+	DefaultStopTimeout = 10 * time.Second
+	// 强制结束进程后等待其退出的时间
+	killTimeout = 5 * time.Second
+	// 默认的cgroup v2父节点，应用的cgroup创建在该节点下
+	DefaultCgroupParent = "/sys/fs/cgroup/magnet"
+
+	// 默认首次重启等待时间
+	DefaultBackoff = time.Second
+	// 默认最大重启等待时间
+	DefaultMaxBackoff = time.Minute
+)
+
+type State int
+
+const (
+	// 未运行
+	StateStopped State = iota
+	// 运行中
+	StateRunning
+	// 进程自行退出
+	StateExited
+	// 等待重启
+	StateBackoff
+	// 重启次数超过限制，不再重启
+	StateFailed
+	// 定时任务等待下次运行
+	StateScheduled
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateRunning:
+		return "running"
+	case StateExited:
+		return "exited"
+	case StateBackoff:
+		return "backoff"
+	case StateFailed:
+		return "failed"
+	case StateScheduled:
+		return "scheduled"
+	}
+	return "unknown"
+}
+
+type Status struct {
+	// 安装包名称
+	Name string
+	// 安装路径
+	InstallPath string
+	// 运行状态
+	State State
+	// 进程id
+	Pid int
+	// 启动命令
+	Cmd []string
+	// 启动时间
+	StartTime time.Time
+	// 退出时间
+	ExitTime time.Time
+	// 最近一次的退出码
+	ExitCode int
+	// 连续重启次数
+	Restarts int
+}
+
+type Supervisor interface {
+	// 启动已安装的应用，使用安装包信息中的ExecCmd启动
+	Start(pkg installer.Package) error
+
+	// 停止应用，按安装包信息中的stopCmd或stopSignal通知应用退出，超过stopTimeout则强制结束
+	Stop(name string) error
+
+	// 重启正在运行的应用
+	Restart(name string) error
+
+	// 向正在运行的应用发送信号
+	Signal(name string, sig syscall.Signal) error
+
+	// 查询应用运行状态
+	Status(name string) (Status, error)
+
+	// 获得应用的运行参数，包括展开变量后的启动命令及环境变量
+	ExecSpec(pkg installer.Package) (*ExecSpec, error)
+
+	// 等待应用健康检查通过，安装包信息中未声明健康检查则直接返回
+	// 健康检查连续失败达到阈值或者进程已退出时返回错误
+	WaitHealthy(name string) error
+
+	// 获得定时任务最近的运行记录，按运行时间由旧到新排列
+	Runs(name string) ([]Run, error)
+
+	// 打开应用的输出日志
+	// Param: since只返回该时间之后的日志（按启动、退出等记录行的时间判断），零值返回所有日志，follow为true则持续输出新增日志直到关闭
+	Logs(pkg installer.Package, since time.Time, follow bool) (io.ReadCloser, error)
+
+	// 接管宿主重启前启动且仍在运行的应用进程，避免重复启动或者遗留孤儿进程
+	// 进程的pid及启动时间记录在状态文件中，启动时间不一致（pid已被复用）的记录将被忽略
+	// 接管的进程继续写入日志文件，进程退出后按重启策略重新启动
+	Adopt(pkgs ...installer.Package) error
+
+	// 添加应用进程事件监听器
+	AddListener(l watcher.ProcessListener)
+
+	// 停止所有应用
+	Close() error
+}
+
+type Opt func(s *ProcessSupervisor)
+
+type process struct {
+	pkg    installer.Package
+	info   *installer.ZipPackageInfo
+	spec   *ExecSpec
+	cmd    *exec.Cmd
+	status Status
+
+	logs     *rotateWriter
+	cgroup   string
+	sched    *schedule
+	runs     []Run
+	stopping bool
+	// 进程启动时间（系统启动后的时钟周期数），用于接管进程时识别pid是否被复用
+	startTicks uint64
+	backoff    time.Duration
+	timer      *time.Timer
+	done       chan struct{}
+}
+
+type ProcessSupervisor struct {
+	procs        map[string]*process
+	listeners    []watcher.ProcessListener
+	userPolicy   installer.UserPolicy
+	env          map[string]string
+	stopTimeout  time.Duration
+	cgroupParent string
+	logMaxSize   int64
+	logBackups   int
+	stateFile    string
+	log          xlog.Logger
+
+	lock sync.Mutex
+}
+
+func NewSupervisor(opts ...Opt) *ProcessSupervisor {
+	ret := &ProcessSupervisor{
+		procs:        map[string]*process{},
+		stopTimeout:  DefaultStopTimeout,
+		cgroupParent: DefaultCgroupParent,
+		logMaxSize:   DefaultLogMaxSize,
+		logBackups:   DefaultLogMaxBackups,
+		log:          xlog.GetLogger(),
+	}
+	for i := range opts {
+		opts[i](ret)
+	}
+	return ret
+}
+
+func (s *ProcessSupervisor) AddListener(l watcher.ProcessListener) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *ProcessSupervisor) getListeners() []watcher.ProcessListener {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.listeners
+}
+
+func (s *ProcessSupervisor) Start(pkg installer.Package) error {
+	info, err := execInfo(pkg)
+	if err != nil {
+		return err
+	}
+	// 进程外插件需要宿主通过标准输入输出通信，由宿主启动
+	if info.Type == installer.PackageTypeRPCPlugin {
+		return errors.New("Package: " + pkg.GetName() + " is a RPC plugin and must be started by host ")
+	}
+
+	s.lock.Lock()
+	if p, ok := s.procs[pkg.GetName()]; ok && p.active() {
+		s.lock.Unlock()
+		return errors.New("Package: " + pkg.GetName() + " is running ")
+	}
+
+	p := &process{
+		pkg:  pkg,
+		info: info,
+		status: Status{
+			Name:        pkg.GetName(),
+			InstallPath: pkg.GetInstallPath(),
+		},
+	}
+	if info.Schedule != nil {
+		err = s.startSchedule(p)
+		if err == nil {
+			s.procs[pkg.GetName()] = p
+		}
+		s.lock.Unlock()
+		return err
+	}
+	err = s.spawn(p)
+	if err != nil {
+		s.lock.Unlock()
+		return err
+	}
+	s.procs[pkg.GetName()] = p
+	s.saveState()
+	pid := p.status.Pid
+	s.lock.Unlock()
+
+	for _, l := range s.getListeners() {
+		l.OnStart(pkg, pid)
+	}
+	return nil
+}
+
+// 启动进程，调用时需持有锁
+func (s *ProcessSupervisor) spawn(p *process) error {
+	if p.logs == nil {
+		logs, err := newRotateWriter(LogDir(p.pkg.GetInstallPath()), s.logMaxSize, s.logBackups)
+		if err != nil {
+			return err
+		}
+		p.logs = logs
+	}
+	err := installer.CheckUser(s.userPolicy, p.info)
+	if err != nil {
+		p.release()
+		return err
+	}
+	spec, err := BuildSpec(p.pkg, p.info, s.env)
+	if err != nil {
+		p.release()
+		return err
+	}
+	err = makeDataDir(spec)
+	if err != nil {
+		p.release()
+		return err
+	}
+	cmd := spec.Command()
+	// 应用直接写入日志文件，宿主退出后应用的输出不受影响
+	cmd.Stdout = p.logs.File()
+	cmd.Stderr = p.logs.File()
+	setProcAttr(cmd)
+	// 声明了资源限制或沙箱时先启动初始化进程
+	initProc, err := initCommand(p.status.Name, cmd, p.info)
+	if err != nil {
+		p.release()
+		return err
+	}
+	p.logs.Mark("start")
+	err = cmd.Start()
+	initProc.started()
+	if err != nil {
+		initProc.Close()
+		p.release()
+		if p.info.Sandbox != nil {
+			// 创建命名空间失败
+			return &SandboxError{Name: p.status.Name, Err: err}
+		}
+		return err
+	}
+	// 初始化进程执行应用前设置资源限制，应用及其子进程均受限制
+	cgroup, err := s.applyLimits(p.status.Name, cmd.Process.Pid, p.info.Limits)
+	if cgroup != "" {
+		p.cgroup = cgroup
+	}
+	if err == nil {
+		err = initProc.Resume()
+	}
+	if err != nil {
+		initProc.Close()
+		killProcess(cmd.Process.Pid)
+		cmd.Wait()
+		p.release()
+		return err
+	}
+	p.spec = spec
+	p.cmd = cmd
+	p.done = make(chan struct{})
+	p.status.Cmd = spec.Args
+	p.status.State = StateRunning
+	p.status.Pid = cmd.Process.Pid
+	p.status.StartTime = time.Now()
+	p.startTicks, _ = processStartTime(p.status.Pid)
+	s.log.Infof("Start package: %s pid: %d cmd: %v\n", p.status.Name, p.status.Pid, spec.Args)
+	s.saveState()
+
+	go s.wait(p, cmd, p.done)
+	return nil
+}
+
+func (s *ProcessSupervisor) wait(p *process, cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
+	s.exited(p, done, cmd.ProcessState.ExitCode(), err)
+}
+
+// 处理进程退出，根据重启策略或定时计划决定下一步状态
+func (s *ProcessSupervisor) exited(p *process, done chan struct{}, exitCode int, err error) {
+	s.lock.Lock()
+	p.status.ExitTime = time.Now()
+	p.status.ExitCode = exitCode
+	s.log.Infof("Package: %s pid: %d exit code: %d error: %v\n", p.status.Name, p.status.Pid, p.status.ExitCode, err)
+	if p.logs != nil {
+		p.logs.Mark("exit pid " + strconv.Itoa(p.status.Pid) + " code " + strconv.Itoa(exitCode))
+	}
+
+	var delay time.Duration
+	restart := false
+	if p.sched != nil {
+		p.addRun(Run{
+			StartTime: p.status.StartTime,
+			EndTime:   p.status.ExitTime,
+			ExitCode:  p.status.ExitCode,
+		})
+	}
+	if p.stopping {
+		p.status.State = StateStopped
+	} else if p.sched != nil {
+		p.status.State = StateScheduled
+	} else {
+		delay, restart = s.nextRestart(p)
+		if restart {
+			p.status.Restarts++
+			p.status.State = StateBackoff
+			p.timer = time.AfterFunc(delay, func() {
+				s.restart(p)
+			})
+		}
+	}
+	if !restart && p.status.State != StateScheduled {
+		p.release()
+	}
+	status := p.status
+	close(done)
+	s.saveState()
+	s.lock.Unlock()
+
+	for _, l := range s.getListeners() {
+		l.OnExit(p.pkg, status.ExitCode, status.Restarts)
+		if restart {
+			l.OnRestart(p.pkg, status.Restarts, delay)
+		}
+	}
+	if status.State == StateFailed {
+		s.notifyFail(p.pkg, errors.New("Package: "+status.Name+" restart "+strconv.Itoa(status.Restarts)+" times, give up"))
+	}
+}
+
+// 根据重启策略计算是否重启及重启等待时间，调用时需持有锁
+func (s *ProcessSupervisor) nextRestart(p *process) (time.Duration, bool) {
+	policy := p.info.Restart
+	if policy == nil {
+		p.status.State = StateExited
+		return 0, false
+	}
+	switch policy.Policy {
+	case installer.RestartAlways:
+	case installer.RestartOnFailure:
+		if p.status.ExitCode == 0 {
+			p.status.State = StateExited
+			return 0, false
+		}
+	default:
+		p.status.State = StateExited
+		return 0, false
+	}
+
+	backoff := policy.Backoff.Duration()
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	maxBackoff := policy.MaxBackoff.Duration()
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	// 运行时间超过最大等待时间视为运行稳定，重新计算重启次数
+	if p.status.ExitTime.Sub(p.status.StartTime) >= maxBackoff {
+		p.status.Restarts = 0
+		p.backoff = 0
+	}
+	if policy.MaxRetries > 0 && p.status.Restarts >= policy.MaxRetries {
+		s.log.Errorf("Package: %s restart %d times, give up\n", p.status.Name, p.status.Restarts)
+		p.status.State = StateFailed
+		return 0, false
+	}
+
+	if p.backoff == 0 {
+		p.backoff = backoff
+	} else {
+		p.backoff *= 2
+	}
+	if p.backoff > maxBackoff {
+		p.backoff = maxBackoff
+	}
+	return p.backoff, true
+}
+
+func (s *ProcessSupervisor) restart(p *process) {
+	s.lock.Lock()
+	if p.status.State != StateBackoff {
+		s.lock.Unlock()
+		return
+	}
+	p.timer = nil
+	err := s.spawn(p)
+	if err != nil {
+		s.log.Errorf("Restart package: %s error: %v\n", p.status.Name, err)
+		p.release()
+		p.status.State = StateFailed
+		s.saveState()
+		s.lock.Unlock()
+		s.notifyFail(p.pkg, err)
+		return
+	}
+	pid := p.status.Pid
+	s.lock.Unlock()
+
+	for _, l := range s.getListeners() {
+		l.OnStart(p.pkg, pid)
+	}
+}
+
+// 通知实现了watcher.FailureListener的监听器应用不再重启
+func (s *ProcessSupervisor) notifyFail(pkg installer.Package, err error) {
+	for _, l := range s.getListeners() {
+		if fl, ok := l.(watcher.FailureListener); ok {
+			fl.OnFail(pkg, err)
+		}
+	}
+}
+
+func (s *ProcessSupervisor) Stop(name string) error {
+	s.lock.Lock()
+	p, ok := s.procs[name]
+	if !ok {
+		s.lock.Unlock()
+		return errors.New("Package: " + name + " is not running ")
+	}
+	switch p.status.State {
+	case StateRunning:
+		p.stopTimer()
+		p.stopping = true
+		s.lock.Unlock()
+		return s.stopProcess(p)
+	case StateBackoff, StateScheduled:
+		p.stopTimer()
+		p.status.State = StateStopped
+		p.release()
+		s.saveState()
+		s.lock.Unlock()
+		return nil
+	}
+	s.lock.Unlock()
+	return errors.New("Package: " + name + " is not running ")
+}
+
+func (s *ProcessSupervisor) Restart(name string) error {
+	s.lock.Lock()
+	p, ok := s.procs[name]
+	s.lock.Unlock()
+	if !ok {
+		return errors.New("Package: " + name + " is not running ")
+	}
+	err := s.Stop(name)
+	if err != nil {
+		return err
+	}
+	return s.Start(p.pkg)
+}
+
+func (s *ProcessSupervisor) Signal(name string, sig syscall.Signal) error {
+	s.lock.Lock()
+	p, ok := s.procs[name]
+	if !ok || p.status.State != StateRunning {
+		s.lock.Unlock()
+		return errors.New("Package: " + name + " is not running ")
+	}
+	pid := p.status.Pid
+	s.lock.Unlock()
+	return signalProcess(pid, sig)
+}
+
+func (s *ProcessSupervisor) stopProcess(p *process) error {
+	s.lock.Lock()
+	pid, spec, done := p.status.Pid, p.spec, p.done
+	s.lock.Unlock()
+
+	grace := p.info.StopTimeout.Duration()
+	if grace <= 0 {
+		grace = s.stopTimeout
+	}
+	err := s.notifyStop(p, pid, spec, grace)
+	if err != nil {
+		s.log.Warnf("Package: %s graceful stop error: %v\n", p.status.Name, err)
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(grace):
+		s.log.Warnf("Package: %s not exit after %v, kill\n", p.status.Name, grace)
+	}
+
+	err = killProcess(pid)
+	if err != nil {
+		select {
+		case <-done:
+			return nil
+		default:
+			return err
+		}
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(killTimeout):
+		return errors.New("Package: " + p.status.Name + " stop timeout ")
+	}
+}
+
+// 执行停止命令或者发送停止信号通知应用退出
+func (s *ProcessSupervisor) notifyStop(p *process, pid int, spec *ExecSpec, timeout time.Duration) error {
+	if p.info.StopCmd != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return spec.commandContext(ctx, spec.Expand(p.info.StopCmd)).Run()
+	}
+	sig, err := ParseSignal(p.info.StopSignal)
+	if err != nil {
+		return err
+	}
+	return signalProcess(pid, sig)
+}
+
+func (s *ProcessSupervisor) ExecSpec(pkg installer.Package) (*ExecSpec, error) {
+	info, err := execInfo(pkg)
+	if err != nil {
+		return nil, err
+	}
+	err = installer.CheckUser(s.userPolicy, info)
+	if err != nil {
+		return nil, err
+	}
+	return BuildSpec(pkg, info, s.env)
+}
+
+func (s *ProcessSupervisor) Logs(pkg installer.Package, since time.Time, follow bool) (io.ReadCloser, error) {
+	return OpenLogs(LogDir(pkg.GetInstallPath()), since, follow)
+}
+
+func (s *ProcessSupervisor) Status(name string) (Status, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p, ok := s.procs[name]
+	if !ok {
+		return Status{Name: name, State: StateStopped}, errors.New("Package: " + name + " not found ")
+	}
+	return p.status, nil
+}
+
+func (s *ProcessSupervisor) Close() error {
+	s.lock.Lock()
+	var running []*process
+	for _, p := range s.procs {
+		switch p.status.State {
+		case StateRunning:
+			p.stopTimer()
+			p.stopping = true
+			running = append(running, p)
+		case StateBackoff, StateScheduled:
+			p.stopTimer()
+			p.status.State = StateStopped
+			p.release()
+		}
+	}
+	s.lock.Unlock()
+
+	var lastErr error
+	for _, p := range running {
+		err := s.stopProcess(p)
+		if err != nil {
+			s.log.Errorf("Stop package: %s error: %v\n", p.status.Name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// 创建应用的数据目录，应用以其他用户运行时修改目录的所有者
+func makeDataDir(spec *ExecSpec) error {
+	dir := spec.Var(VarDataDir)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	if spec.Uid >= 0 || spec.Gid >= 0 {
+		return os.Lchown(dir, spec.Uid, spec.Gid)
+	}
+	return nil
+}
+
+// 是否正在运行或者等待运行
+func (p *process) active() bool {
+	switch p.status.State {
+	case StateRunning, StateBackoff, StateScheduled:
+		return true
+	}
+	return false
+}
+
+func (p *process) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// 释放进程的日志文件及cgroup，进程不再重启时调用
+func (p *process) release() {
+	if p.logs != nil {
+		p.logs.Close()
+		p.logs = nil
+	}
+	if p.cgroup != "" {
+		removeCgroup(p.cgroup)
+		p.cgroup = ""
+	}
+}
+
+func execInfo(pkg installer.Package) (*installer.ZipPackageInfo, error) {
+	info, ok := installer.GetPackageInfo(pkg).(*installer.ZipPackageInfo)
+	if !ok || info == nil {
+		return nil, errors.New("Package: " + pkg.GetName() + " exec info not found ")
+	}
+	if info.Type == installer.PackageTypeGoPlugin {
+		return nil, errors.New("Package: " + pkg.GetName() + " is a Go plugin and cannot be run as process ")
+	}
+	return info, nil
+}
+
+// 设置停止应用时等待进程退出的默认时间，安装包信息中声明了stopTimeout时以安装包为准
+func SetStopTimeout(t time.Duration) Opt {
+	return func(s *ProcessSupervisor) {
+		s.stopTimeout = t
+	}
+}
+
+// 设置应用输出日志的轮转策略，maxSize为单个日志文件最大大小，maxBackups为保留的轮转文件个数
+func SetLogRotate(maxSize int64, maxBackups int) Opt {
+	return func(s *ProcessSupervisor) {
+		s.logMaxSize = maxSize
+		s.logBackups = maxBackups
+	}
+}
+
+// 设置宿主的环境变量，覆盖安装包中声明的同名环境变量
+func SetEnv(env map[string]string) Opt {
+	return func(s *ProcessSupervisor) {
+		s.env = env
+	}
+}
+
+// 设置应用cgroup的父节点，需位于cgroup v2层级下且可写，为空则不使用cgroup
+func SetCgroupParent(parent string) Opt {
+	return func(s *ProcessSupervisor) {
+		s.cgroupParent = parent
+	}
+}
+
+// 设置用户策略，限制安装包可以声明的运行用户及用户组，默认不限制
+func SetUserPolicy(p installer.UserPolicy) Opt {
+	return func(s *ProcessSupervisor) {
+		s.userPolicy = p
+	}
+}
+
+// 设置应用进程事件监听器
+func SetListener(l watcher.ProcessListener) Opt {
+	return func(s *ProcessSupervisor) {
+		s.listeners = append(s.listeners, l)
+	}
+}
+
+// 设置进程状态文件，用于记录运行中应用的pid及启动时间，宿主重启后通过Adopt接管仍在运行的进程
+// 为空则不记录
+func SetStateFile(path string) Opt {
+	return func(s *ProcessSupervisor) {
+		s.stateFile = path
+	}
+}
+
+// 设置日志
+func SetLogger(l xlog.Logger) Opt {
+	return func(s *ProcessSupervisor) {
+		s.log = l
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package supervisor
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createPackage(t *testing.T, script string) *installer.ZipPackage {
+	dir, err := ioutil.TempDir("", "magnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+		os.RemoveAll(LogDir(dir))
+		os.RemoveAll(DataDir(dir))
+	})
+	err = ioutil.WriteFile(filepath.Join(dir, "run.sh"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &installer.ZipPackage{
+		Name:        "test",
+		Version:     1,
+		InstallPath: dir,
+		PkgInfo: &installer.ZipPackageInfo{
+			Name:       "test",
+			AppVersion: 1,
+			ExecCmd:    "sh ${EXECUTABLE}",
+			ExecName:   "run.sh",
+		},
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	pkg := createPackage(t, "sleep 10\n")
+
+	s := NewSupervisor()
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := s.Status("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != StateRunning || st.Pid == 0 {
+		t.Fatal("expect running, got ", st.State)
+	}
+	if st.Cmd[1] != filepath.Join(pkg.InstallPath, "run.sh") {
+		t.Fatal("executable not expanded: ", st.Cmd)
+	}
+
+	err = s.Start(pkg)
+	if err == nil {
+		t.Fatal("expect start running package failed")
+	}
+
+	err = s.Stop("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ = s.Status("test")
+	if st.State != StateStopped {
+		t.Fatal("expect stopped, got ", st.State)
+	}
+}
+
+func TestSupervisorExit(t *testing.T) {
+	pkg := createPackage(t, "exit 3\n")
+
+	s := NewSupervisor()
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	st, _ := s.Status("test")
+	if st.State != StateExited || st.ExitCode != 3 {
+		t.Fatal("expect exited with code 3, got ", st.State, st.ExitCode)
+	}
+}
+
+type restartListener struct {
+	restarts chan int
+	fails    chan error
+}
+
+func (l *restartListener) OnStart(p installer.Package, pid int) {}
+
+func (l *restartListener) OnExit(p installer.Package, exitCode int, restarts int) {}
+
+func (l *restartListener) OnRestart(p installer.Package, restarts int, delay time.Duration) {
+	l.restarts <- restarts
+}
+
+func (l *restartListener) OnFail(p installer.Package, err error) {
+	l.fails <- err
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	pkg := createPackage(t, "exit 1\n")
+	pkg.PkgInfo.Restart = &installer.RestartPolicy{
+		Policy:     installer.RestartOnFailure,
+		MaxRetries: 3,
+		Backoff:    installer.Duration(10 * time.Millisecond),
+	}
+
+	l := &restartListener{restarts: make(chan int, 10), fails: make(chan error, 10)}
+	s := NewSupervisor(SetListener(l))
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	st, _ := s.Status("test")
+	if st.State != StateFailed || st.Restarts != 3 || st.ExitCode != 1 {
+		t.Fatal("expect failed after 3 restarts, got ", st.State, st.Restarts, st.ExitCode)
+	}
+	if len(l.restarts) != 3 {
+		t.Fatal("expect 3 restart events, got ", len(l.restarts))
+	}
+	if len(l.fails) != 1 {
+		t.Fatal("expect 1 fail event, got ", len(l.fails))
+	}
+	t.Log(<-l.fails)
+}
+
+// 重启时启动失败，不再重启并通知监听器
+func TestSupervisorRestartFailed(t *testing.T) {
+	pkg := createPackage(t, "rm -rf \"$(pwd)\"\nexit 1\n")
+	pkg.PkgInfo.Restart = &installer.RestartPolicy{
+		Policy:  installer.RestartOnFailure,
+		Backoff: installer.Duration(10 * time.Millisecond),
+	}
+
+	l := &restartListener{restarts: make(chan int, 10), fails: make(chan error, 10)}
+	s := NewSupervisor(SetListener(l))
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-l.fails:
+		t.Log(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect fail event")
+	}
+	st, _ := s.Status("test")
+	if st.State != StateFailed {
+		t.Fatal("expect failed, got ", st.State)
+	}
+}
+
+func TestWaitHealthy(t *testing.T) {
+	pkg := createPackage(t, "touch ready\nsleep 10\n")
+	pkg.PkgInfo.HealthCheck = &installer.HealthCheck{
+		Exec:      "test -f ready",
+		Interval:  installer.Duration(100 * time.Millisecond),
+		Threshold: 20,
+	}
+
+	s := NewSupervisor()
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WaitHealthy("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Stop("test")
+
+	pkg.PkgInfo.HealthCheck.Exec = "test -f notexists"
+	pkg.PkgInfo.HealthCheck.Threshold = 2
+	err = s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WaitHealthy("test")
+	if err == nil {
+		t.Fatal("expect unhealthy")
+	}
+	t.Log(err)
+}
+
+// 启动宽限期内的检查失败不计入连续失败次数，超过总超时时间返回错误
+func TestWaitHealthyStartPeriod(t *testing.T) {
+	pkg := createPackage(t, "sleep 0.5\ntouch ready\nsleep 10\n")
+	pkg.PkgInfo.HealthCheck = &installer.HealthCheck{
+		Exec:        "test -f ready",
+		Interval:    installer.Duration(100 * time.Millisecond),
+		Threshold:   1,
+		StartPeriod: installer.Duration(2 * time.Second),
+	}
+
+	s := NewSupervisor()
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WaitHealthy("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Stop("test")
+
+	pkg.PkgInfo.HealthCheck.Exec = "test -f notexists"
+	pkg.PkgInfo.HealthCheck.WaitTimeout = installer.Duration(time.Second)
+	err = s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	err = s.WaitHealthy("test")
+	if err == nil {
+		t.Fatal("expect unhealthy")
+	}
+	if time.Since(now) > 2*time.Second {
+		t.Fatal("expect timeout in 1s, got ", time.Since(now))
+	}
+	t.Log(err)
+}
+
+func TestGracefulStop(t *testing.T) {
+	pkg := createPackage(t, "trap 'echo term; exit 0' TERM\nwhile true; do sleep 0.1; done\n")
+
+	s := NewSupervisor()
+	defer s.Close()
+
+	err := s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	err = s.Stop("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := s.Status("test")
+	if st.ExitCode != 0 {
+		t.Fatal("expect graceful exit, got ", st.ExitCode)
+	}
+
+	pkg.PkgInfo.StopCmd = "touch stopped"
+	pkg.PkgInfo.StopTimeout = installer.Duration(5 * time.Second)
+	err = ioutil.WriteFile(filepath.Join(pkg.InstallPath, "run.sh"), []byte("while [ ! -f stopped ]; do sleep 0.1; done\nexit 2\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Stop("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ = s.Status("test")
+	if st.ExitCode != 2 {
+		t.Fatal("expect stop by stopCmd, got ", st.ExitCode)
+	}
+}
+
+func TestRunAsUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("run as user requires root")
+	}
+	pkg := createPackage(t, "id -u\n")
+	os.Chmod(pkg.InstallPath, 0755)
+	pkg.PkgInfo.User = "nobody"
+
+	s := NewSupervisor(SetUserPolicy(installer.NewUserPolicy([]string{"daemon"}, nil)))
+	defer s.Close()
+	err := s.Start(pkg)
+	if err == nil {
+		t.Fatal("expect user not allowed")
+	}
+	t.Log(err)
+
+	s = NewSupervisor(SetUserPolicy(installer.NewUserPolicy([]string{"nobody"}, nil)))
+	defer s.Close()
+	err = s.Start(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	spec, _ := s.ExecSpec(pkg)
+	r, err := s.Logs(pkg, time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	d, _ := ioutil.ReadAll(r)
+	if !strings.Contains(string(d), "\n"+strconv.Itoa(spec.Uid)+"\n") {
+		t.Fatal("expect run as nobody, got ", string(d))
+	}
+}
@@ -21,6 +21,22 @@ type PackageListener interface {
 	OnRemove(p installer.Package, filename string)
 }
 
+// 应用进程事件监听器，PackageListener可以同时实现该接口以获得应用进程的事件
+type ProcessListener interface {
+	// 应用进程启动
+	OnStart(p installer.Package, pid int)
+	// 应用进程退出，exitCode为退出码，restarts为已连续重启的次数
+	OnExit(p installer.Package, exitCode int, restarts int)
+	// 应用进程将在delay之后重启，restarts为本次重启的序号
+	OnRestart(p installer.Package, restarts int, delay time.Duration)
+}
+
+// 应用进程失败事件监听器，ProcessListener可以同时实现该接口以获得应用不再重启的事件
+type FailureListener interface {
+	// 应用进程重启时启动失败，或重启次数达到上限，不再重启
+	OnFail(p installer.Package, err error)
+}
+
 type Watcher interface {
 	AddListener(PackageListener)
 	Watch(p installer.Package)
@@ -195,3 +211,19 @@ func (l *DummyListener) OnUpdate(p installer.Package, filename string) {
 func (l *DummyListener) OnRemove(p installer.Package, filename string) {
 	fmt.Printf("package : %v remove, file : %s\n", p, filename)
 }
+
+func (l *DummyListener) OnStart(p installer.Package, pid int) {
+	fmt.Printf("package : %v start, pid : %d\n", p, pid)
+}
+
+func (l *DummyListener) OnExit(p installer.Package, exitCode int, restarts int) {
+	fmt.Printf("package : %v exit, code : %d restarts : %d\n", p, exitCode, restarts)
+}
+
+func (l *DummyListener) OnRestart(p installer.Package, restarts int, delay time.Duration) {
+	fmt.Printf("package : %v restart, restarts : %d delay : %v\n", p, restarts, delay)
+}
+
+func (l *DummyListener) OnFail(p installer.Package, err error) {
+	fmt.Printf("package : %v fail, error : %v\n", p, err)
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package magnet
+
+import (
+	"errors"
+	"github.com/xfali/magnet/pkg/goplugin"
+	"github.com/xfali/magnet/pkg/installer"
+	"github.com/xfali/magnet/pkg/rpcplugin"
+	"path/filepath"
+	"strings"
+)
+
+// 获得已加载的Go插件，宿主通过插件的符号表调用插件
+func (m *Magnet) Plugin(name string) (*goplugin.Plugin, error) {
+	m.pluginLock.Lock()
+	defer m.pluginLock.Unlock()
+	p, ok := m.plugins[name]
+	if !ok {
+		return nil, errors.New("Plugin: " + name + " not loaded ")
+	}
+	return p, nil
+}
+
+func isGoPlugin(pkg installer.Package) bool {
+	info, ok := installer.GetPackageInfo(pkg).(*installer.ZipPackageInfo)
+	return ok && info != nil && info.Type == installer.PackageTypeGoPlugin
+}
+
+// Go插件加载后无法卸载，同名的插件已加载时无法安装新版本，返回goplugin.ReloadError
+func (m *Magnet) checkPluginReload(info installer.PackageInfo) error {
+	zi, ok := info.(*installer.ZipPackageInfo)
+	if !ok || zi == nil || zi.Type != installer.PackageTypeGoPlugin {
+		return nil
+	}
+	m.pluginLock.Lock()
+	p, ok := m.plugins[info.GetName()]
+	m.pluginLock.Unlock()
+	if !ok {
+		return nil
+	}
+	return &goplugin.ReloadError{Path: p.Path}
+}
+
+// 加载Go插件，查找声明的符号并调用Init
+// 卸载插件后安装了不同的插件文件时返回goplugin.ReloadError，新的插件需重启宿主后才能加载
+func (m *Magnet) loadPlugin(pkg installer.Package) error {
+	info := installer.GetPackageInfo(pkg).(*installer.ZipPackageInfo)
+	p, err := goplugin.Open(pkg.GetName(), filepath.Join(pkg.GetInstallPath(), info.ExecName), info.Symbols)
+	if err != nil {
+		return err
+	}
+	err = p.Call(goplugin.SymbolInit)
+	if err != nil {
+		return err
+	}
+
+	m.pluginLock.Lock()
+	defer m.pluginLock.Unlock()
+	m.plugins[pkg.GetName()] = p
+	return nil
+}
+
+// 调用插件的Stop并移除插件，Go插件加载后无法从进程中卸载
+func (m *Magnet) unloadPlugin(pkg installer.Package) error {
+	m.pluginLock.Lock()
+	p, ok := m.plugins[pkg.GetName()]
+	if ok && strings.HasPrefix(p.Path, filepath.Clean(pkg.GetInstallPath())+string(filepath.Separator)) {
+		delete(m.plugins, pkg.GetName())
+	} else {
+		ok = false
+	}
+	m.pluginLock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return p.Call(goplugin.SymbolStop)
+}
+
+func (m *Magnet) callPlugin(name, symbol string) error {
+	p, err := m.Plugin(name)
+	if err != nil {
+		return err
+	}
+	return p.Call(symbol)
+}
+
+// 获得运行中的进程外插件客户端，宿主通过客户端调用插件，插件升级后客户端自动使用新的插件进程
+func (m *Magnet) RPCPlugin(name string) (*rpcplugin.Client, error) {
+	m.pluginLock.Lock()
+	defer m.pluginLock.Unlock()
+	c, ok := m.rpcPlugins[name]
+	if !ok {
+		return nil, errors.New("Plugin: " + name + " not running ")
+	}
+	return c, nil
+}
+
+func isRPCPlugin(pkg installer.Package) bool {
+	info, ok := installer.GetPackageInfo(pkg).(*installer.ZipPackageInfo)
+	return ok && info != nil && info.Type == installer.PackageTypeRPCPlugin
+}
+
+// 启动进程外插件，插件已运行时替换插件进程
+func (m *Magnet) startRPCPlugin(pkg installer.Package) error {
+	spec, err := m.supervisor.ExecSpec(pkg)
+	if err != nil {
+		return err
+	}
+	c, err := m.RPCPlugin(pkg.GetName())
+	if err == nil {
+		return c.Swap(spec.Command())
+	}
+	c, err = rpcplugin.Start(pkg.GetName(), spec.Command())
+	if err != nil {
+		return err
+	}
+
+	m.pluginLock.Lock()
+	defer m.pluginLock.Unlock()
+	m.rpcPlugins[pkg.GetName()] = c
+	return nil
+}
+
+func (m *Magnet) stopRPCPlugin(name string) error {
+	m.pluginLock.Lock()
+	c, ok := m.rpcPlugins[name]
+	delete(m.rpcPlugins, name)
+	m.pluginLock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return c.Close()
+}
+
+func (m *Magnet) closeRPCPlugins() {
+	m.pluginLock.Lock()
+	clients := m.rpcPlugins
+	m.rpcPlugins = map[string]*rpcplugin.Client{}
+	m.pluginLock.Unlock()
+
+	for _, c := range clients {
+		c.Close()
+	}
+}
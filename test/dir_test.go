@@ -0,0 +1,77 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createDirPackage(t *testing.T) string {
+	dir := filepath.Join(tempDir(t), "hello")
+	err := os.MkdirAll(filepath.Join(dir, "conf"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"pkg.info":      info,
+		"hello":         nil,
+		"conf/app.conf": []byte("a=1\n"),
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDirInstall(t *testing.T) {
+	for _, link := range []bool{false, true} {
+		src := createDirPackage(t)
+		inst, err := installer.CreateDirInstaller(filepath.Join(tempDir(t), "target"), installer.SetHardlink(link))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := inst.Install(src, installer.NewStrategy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcFile, err := os.Stat(filepath.Join(src, "conf/app.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dstFile, err := os.Stat(filepath.Join(pkg.GetInstallPath(), "conf/app.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if os.SameFile(srcFile, dstFile) != link {
+			t.Fatal("expect hardlink ", link)
+		}
+		if dstFile.Mode().Perm() != 0755 {
+			t.Fatal("expect mode 0755, got ", dstFile.Mode())
+		}
+
+		err = inst.Uninstall(pkg, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(pkg.GetInstallPath()); !os.IsNotExist(err) {
+			t.Fatal("expect install path removed")
+		}
+		if _, err := os.Stat(filepath.Join(src, "pkg.info")); err != nil {
+			t.Fatal("expect package dir kept: ", err)
+		}
+	}
+}
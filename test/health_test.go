@@ -0,0 +1,65 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/zip"
+	"github.com/xfali/magnet"
+	"github.com/xfali/magnet/pkg/installer"
+	"github.com/xfali/magnet/pkg/supervisor"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 健康检查失败时删除安装目录及本次启动创建的日志、数据目录
+func TestInstallUnhealthy(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "unhealthy.pkg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range map[string]string{
+		"pkg.info": `{"protocolVersion": 2, "name": "unhealthy", "appVersion": 1, "execName": "run.sh", "execCmd": "sh ${EXECUTABLE}",
+			"healthCheck": {"exec": "false", "interval": "50ms", "threshold": 2}}`,
+		"run.sh": "echo data > \"$DATA_DIR/data\"\nsleep 10\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	zw.Close()
+	f.Close()
+
+	r, err := installer.CreateJsonRecorder(filepath.Join(dir, "pkg.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := installer.CreateInstaller(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := magnet.New(magnet.SetRecorder(r), magnet.SetInstaller(inst))
+	defer m.Close()
+	_, err = m.Install(path, magnet.InstallFlagNotExists|magnet.InstallFlagWaitHealthy)
+	if err == nil {
+		t.Fatal("expect unhealthy")
+	}
+	t.Log(err)
+	installPath := filepath.Join(dir, "target", "unhealthy")
+	for _, p := range []string{installPath, supervisor.LogDir(installPath), supervisor.DataDir(installPath)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatal("expect removed: ", p)
+		}
+	}
+	if len(m.GetPackage("unhealthy")) != 0 {
+		t.Fatal("expect not recorded, got ", m.GetPackage("unhealthy"))
+	}
+}
@@ -8,9 +8,23 @@ package test
 import (
 	"github.com/xfali/magnet/pkg/installer"
 	"github.com/xfali/stream"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
+// 创建临时目录，测试结束时删除
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "magnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
 func TestInstall(t *testing.T) {
 	inst, err := installer.CreateInstaller("./target")
 	if err != nil {
@@ -0,0 +1,301 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type zipEntry struct {
+	name string
+	data string
+}
+
+func integrityInfo(files []installer.FileEntry) []byte {
+	info, _ := json.Marshal(map[string]interface{}{
+		"protocolVersion": 2,
+		"appVersion":      1,
+		"name":            "integrity",
+		"execName":        "bin/app",
+		"files":           files,
+	})
+	return info
+}
+
+func createIntegrityZip(t *testing.T, path string, files []installer.FileEntry, entries []zipEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+	entries = append([]zipEntry{{"pkg.info", string(integrityInfo(files))}}, entries...)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.data))
+	}
+}
+
+func fileEntry(path, data string) installer.FileEntry {
+	sum := sha256.Sum256([]byte(data))
+	return installer.FileEntry{Path: path, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+}
+
+func TestIntegrityInstall(t *testing.T) {
+	files := []installer.FileEntry{
+		fileEntry("bin/app", "#!/bin/sh\necho hello\n"),
+		fileEntry("conf/app.conf", "key=value\n"),
+		fileEntry("empty", ""),
+	}
+	entries := []zipEntry{
+		{"bin/", ""},
+		{"bin/app", "#!/bin/sh\necho hello\n"},
+		{"conf/app.conf", "key=value\n"},
+		{"empty", ""},
+	}
+	dir := tempDir(t)
+	pkgPath := filepath.Join(dir, "app.pkg")
+	createIntegrityZip(t, pkgPath, files, entries)
+
+	inst, err := installer.CreateInstaller(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := inst.Install(pkgPath, installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ioutil.ReadFile(filepath.Join(pkg.GetInstallPath(), "conf", "app.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != "key=value\n" {
+		t.Fatal("expect key=value got ", string(d))
+	}
+	pkg.Uninstall(false)
+}
+
+func TestIntegrityFailed(t *testing.T) {
+	files := []installer.FileEntry{
+		fileEntry("bin/app", "app"),
+		fileEntry("conf/app.conf", "key=value"),
+	}
+	cases := map[string][]zipEntry{
+		"extra":   {{"bin/app", "app"}, {"conf/app.conf", "key=value"}, {"evil", "evil"}},
+		"missing": {{"bin/app", "app"}},
+		"size":    {{"bin/app", "app"}, {"conf/app.conf", "key=value2"}},
+		"sha256":  {{"bin/app", "app"}, {"conf/app.conf", "key=VALUE"}},
+		// 替换为空文件
+		"empty": {{"bin/app", ""}, {"conf/app.conf", "key=value"}},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := tempDir(t)
+			pkgPath := filepath.Join(dir, "app.pkg")
+			createIntegrityZip(t, pkgPath, files, entries)
+
+			installDir := filepath.Join(dir, "target")
+			inst, err := installer.CreateInstaller(installDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkg, err := inst.Install(pkgPath, installer.NewStrategy())
+			var ierr *installer.IntegrityError
+			if !errors.As(err, &ierr) {
+				t.Fatal("expect IntegrityError, got ", err)
+			}
+			if pkg != nil {
+				t.Fatal("expect nil package")
+			}
+			t.Log(err)
+			list, _ := ioutil.ReadDir(installDir)
+			if len(list) != 0 {
+				t.Fatal("expect install dir empty, got ", len(list))
+			}
+		})
+	}
+}
+
+func TestIntegrityManifestInvalid(t *testing.T) {
+	cases := map[string]installer.FileEntry{
+		"sha256": {Path: "bin/app", SHA256: "1234", Size: 3},
+		"path":   {Path: "../app", SHA256: fileEntry("", "").SHA256},
+		"size":   {Path: "bin/app", SHA256: fileEntry("", "").SHA256, Size: -1},
+	}
+	for name, entry := range cases {
+		t.Run(name, func(t *testing.T) {
+			pkgPath := filepath.Join(tempDir(t), "app.pkg")
+			createIntegrityZip(t, pkgPath, []installer.FileEntry{entry}, []zipEntry{{"bin/app", "app"}})
+			inst, err := installer.CreateInstaller(filepath.Join(tempDir(t), "target"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = inst.Install(pkgPath, installer.NewStrategy())
+			var merr *installer.ManifestError
+			if !errors.As(err, &merr) {
+				t.Fatal("expect ManifestError, got ", err)
+			}
+			t.Log(err)
+		})
+	}
+}
+
+// 以->开头的内容为符号链接的目标，manifestLast为true时描述文件位于最后，先解压的文件在读取描述文件后校验
+func createIntegrityTar(t *testing.T, path string, files []installer.FileEntry, entries []zipEntry, manifestLast bool) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	defer tw.Close()
+	manifest := zipEntry{"pkg.info", string(integrityInfo(files))}
+	if manifestLast {
+		entries = append(entries, manifest)
+	} else {
+		entries = append([]zipEntry{manifest}, entries...)
+	}
+	for _, e := range entries {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0755, Size: int64(len(e.data))}
+		if strings.HasPrefix(e.data, "->") {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.data[2:], 0
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, e.data[len(e.data)-int(hdr.Size):])
+	}
+}
+
+func createIntegrityDir(t *testing.T, path string, files []installer.FileEntry, entries []zipEntry) {
+	entries = append(entries, zipEntry{"pkg.info", string(integrityInfo(files))})
+	for _, e := range entries {
+		name := filepath.Join(path, e.name)
+		err := os.MkdirAll(filepath.Dir(name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(e.data, "->") {
+			err = os.Symlink(e.data[2:], name)
+		} else {
+			err = ioutil.WriteFile(name, []byte(e.data), 0755)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func createIntegrityOCI(t *testing.T, path string, files []installer.FileEntry, entries []zipEntry) {
+	layer := []ociEntry{{"pkg.info", tar.TypeReg, string(integrityInfo(files))}}
+	for _, e := range entries {
+		layer = append(layer, ociEntry{e.name, tar.TypeReg, e.data})
+	}
+	createOCILayout(t, path, nil, layer)
+}
+
+func TestIntegrityFormats(t *testing.T) {
+	formats := []struct {
+		name   string
+		create func(t *testing.T, path string, files []installer.FileEntry, entries []zipEntry)
+		inst   func(dir string) (installer.Installer, error)
+		link   bool
+	}{
+		{"tar", func(t *testing.T, path string, files []installer.FileEntry, entries []zipEntry) {
+			createIntegrityTar(t, path, files, entries, false)
+		}, func(dir string) (installer.Installer, error) {
+			return installer.CreateTarInstaller(dir)
+		}, true},
+		{"tar-manifest-last", func(t *testing.T, path string, files []installer.FileEntry, entries []zipEntry) {
+			createIntegrityTar(t, path, files, entries, true)
+		}, func(dir string) (installer.Installer, error) {
+			return installer.CreateTarInstaller(dir)
+		}, true},
+		{"dir", createIntegrityDir, func(dir string) (installer.Installer, error) {
+			return installer.CreateDirInstaller(dir)
+		}, true},
+		{"oci", createIntegrityOCI, func(dir string) (installer.Installer, error) {
+			return installer.CreateOCIInstaller(dir)
+		}, false},
+	}
+	files := []installer.FileEntry{
+		fileEntry("bin/app", "app"),
+		fileEntry("conf/app.conf", "key=value"),
+	}
+	cases := map[string][]zipEntry{
+		"ok":      {{"bin/app", "app"}, {"conf/app.conf", "key=value"}},
+		"extra":   {{"bin/app", "app"}, {"conf/app.conf", "key=value"}, {"evil", "evil"}},
+		"missing": {{"bin/app", "app"}},
+		"sha256":  {{"bin/app", "app"}, {"conf/app.conf", "key=VALUE"}},
+	}
+	for _, f := range formats {
+		for name, entries := range cases {
+			t.Run(f.name+"-"+name, func(t *testing.T) {
+				dir := tempDir(t)
+				pkgPath := filepath.Join(dir, "app.pkg")
+				f.create(t, pkgPath, files, entries)
+				inst, err := f.inst(filepath.Join(dir, "target"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				pkg, err := inst.Install(pkgPath, installer.NewStrategy())
+				if name == "ok" {
+					if err != nil {
+						t.Fatal(err)
+					}
+					pkg.Uninstall(false)
+					return
+				}
+				var ierr *installer.IntegrityError
+				if !errors.As(err, &ierr) {
+					t.Fatal("expect IntegrityError, got ", err)
+				}
+				t.Log(err)
+			})
+		}
+		if !f.link {
+			continue
+		}
+		// 符号链接的内容为链接目标
+		t.Run(f.name+"-symlink", func(t *testing.T) {
+			links := append(files, fileEntry("bin/run", "app"))
+			for _, target := range []string{"app", "../conf/app.conf"} {
+				dir := tempDir(t)
+				pkgPath := filepath.Join(dir, "app.pkg")
+				f.create(t, pkgPath, links, []zipEntry{{"bin/app", "app"}, {"conf/app.conf", "key=value"}, {"bin/run", "->" + target}})
+				inst, err := f.inst(filepath.Join(dir, "target"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = inst.Install(pkgPath, installer.NewStrategy())
+				var ierr *installer.IntegrityError
+				if target == "app" && err != nil {
+					t.Fatal(err)
+				}
+				if target != "app" && !errors.As(err, &ierr) {
+					t.Fatal("expect IntegrityError, got ", err)
+				}
+			}
+		})
+	}
+}
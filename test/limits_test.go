@@ -0,0 +1,182 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractLimits(t *testing.T) {
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		limit  string
+		limits installer.ExtractLimits
+		name   string
+		size   int
+	}{
+		{"maxFiles", installer.ExtractLimits{MaxFiles: 2}, "data", 1},
+		{"maxFileSize", installer.ExtractLimits{MaxFileSize: 1024}, "data", 2048},
+		{"maxTotalSize", installer.ExtractLimits{MaxTotalSize: 4096}, "data", 4096},
+		{"maxDepth", installer.ExtractLimits{MaxDepth: 2}, "a/b/c", 1},
+		// 全0数据的压缩比约为1000
+		{"maxRatio", installer.ExtractLimits{MaxRatio: 100}, "data", 8 << 20},
+	}
+	for _, c := range cases {
+		t.Run(c.limit, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			tw := tar.NewWriter(gw)
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "pkg.info", Mode: 0644, Size: int64(len(info))})
+			tw.Write(info)
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "hello", Mode: 0755})
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: c.name, Mode: 0644, Size: int64(c.size)})
+			tw.Write(make([]byte, c.size))
+			tw.Close()
+			gw.Close()
+
+			installDir := filepath.Join(tempDir(t), "target")
+			inst, err := installer.CreateTarInstaller(installDir, installer.SetExtractLimits(c.limits))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = inst.InstallFrom(buf, -1, installer.NewStrategy())
+			var lerr *installer.LimitError
+			if !errors.As(err, &lerr) {
+				t.Fatal("expect LimitError, got ", err)
+			}
+			if lerr.Limit != c.limit {
+				t.Fatal("expect ", c.limit, " got ", lerr.Limit)
+			}
+			t.Log(err)
+			files, _ := ioutil.ReadDir(installDir)
+			if len(files) != 0 {
+				t.Fatal("expect install dir empty, got ", len(files))
+			}
+		})
+	}
+}
+
+func TestZipBomb(t *testing.T) {
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("pkg.info")
+	w.Write(info)
+	zw.Create("hello")
+	w, _ = zw.Create("bomb")
+	w.Write(make([]byte, 8<<20))
+	zw.Close()
+
+	installDir := filepath.Join(tempDir(t), "target")
+	inst, err := installer.CreateInstaller(installDir, installer.SetExtractLimits(installer.ExtractLimits{MaxRatio: 100}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = inst.InstallFrom(bytes.NewReader(buf.Bytes()), int64(buf.Len()), installer.NewStrategy())
+	var lerr *installer.LimitError
+	if !errors.As(err, &lerr) || lerr.Limit != "maxRatio" {
+		t.Fatal("expect maxRatio LimitError, got ", err)
+	}
+	t.Log(err)
+	files, _ := ioutil.ReadDir(installDir)
+	if len(files) != 0 {
+		t.Fatal("expect install dir empty, got ", len(files))
+	}
+
+	// 默认限制下正常安装
+	inst, err = installer.CreateInstaller(installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := inst.Install("./assets/hello.pkg", installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.Uninstall(false)
+}
+
+func TestReadInfoLimits(t *testing.T) {
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 描述文件位于解压炸弹之后
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "bomb", Mode: 0644, Size: 8 << 20})
+	tw.Write(make([]byte, 8<<20))
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "pkg.info", Mode: 0644, Size: int64(len(info))})
+	tw.Write(info)
+	tw.Close()
+	gw.Close()
+
+	dir := tempDir(t)
+	pkgPath := filepath.Join(dir, "bomb.tar.gz")
+	err = ioutil.WriteFile(pkgPath, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := installer.CreateTarInstaller(filepath.Join(dir, "target"), installer.SetExtractLimits(installer.ExtractLimits{MaxRatio: 100}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = inst.ReadInfo(pkgPath)
+	var lerr *installer.LimitError
+	if !errors.As(err, &lerr) || lerr.Limit != "maxRatio" {
+		t.Fatal("expect maxRatio LimitError, got ", err)
+	}
+	t.Log(err)
+}
+
+func TestDirLimits(t *testing.T) {
+	cases := []struct {
+		limit  string
+		limits installer.ExtractLimits
+	}{
+		{"maxFiles", installer.ExtractLimits{MaxFiles: 2}},
+		{"maxFileSize", installer.ExtractLimits{MaxFileSize: 2}},
+		{"maxTotalSize", installer.ExtractLimits{MaxTotalSize: 2}},
+		{"maxDepth", installer.ExtractLimits{MaxDepth: 1}},
+	}
+	for _, c := range cases {
+		t.Run(c.limit, func(t *testing.T) {
+			pkgDir := createDirPackage(t)
+			installDir := filepath.Join(tempDir(t), "target")
+			inst, err := installer.CreateDirInstaller(installDir, installer.SetExtractLimits(c.limits))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = inst.Install(pkgDir, installer.NewStrategy())
+			var lerr *installer.LimitError
+			if !errors.As(err, &lerr) {
+				t.Fatal("expect LimitError, got ", err)
+			}
+			if lerr.Limit != c.limit {
+				t.Fatal("expect ", c.limit, " got ", lerr.Limit)
+			}
+			t.Log(err)
+			files, _ := ioutil.ReadDir(installDir)
+			if len(files) != 0 {
+				t.Fatal("expect install dir empty, got ", len(files))
+			}
+		})
+	}
+}
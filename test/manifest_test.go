@@ -0,0 +1,101 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"testing"
+	"time"
+)
+
+const yamlManifest = `# hand-written manifest
+protocolVersion: 1
+appVersion: 2
+name: test
+execName: hello
+execCmd: ${EXECUTABLE} -c conf
+restart:
+  policy: on-failure
+  backoff: 2s
+env:
+  PORT: 8080
+limits:
+  memory: 256M
+`
+
+const tomlManifest = `# hand-written manifest
+protocolVersion = 1
+appVersion = 2
+name = "test"
+execName = "hello"
+execCmd = "${EXECUTABLE} -c conf"
+
+[restart]
+policy = "on-failure"
+backoff = "2s"
+
+[env]
+PORT = 8080
+
+[limits]
+memory = "256M"
+`
+
+func checkManifest(t *testing.T, info *installer.ZipPackageInfo) {
+	if info.Name != "test" || info.AppVersion != 2 || info.ExecName != "hello" {
+		t.Fatal("unexpected info: ", info)
+	}
+	if info.Restart == nil || info.Restart.Policy != installer.RestartOnFailure || info.Restart.Backoff.Duration() != 2*time.Second {
+		t.Fatal("unexpected restart: ", info.Restart)
+	}
+	if info.Env["PORT"] != "8080" {
+		t.Fatal("expect PORT 8080, got ", info.Env)
+	}
+	if info.Limits == nil || info.Limits.Memory != 256<<20 {
+		t.Fatal("unexpected limits: ", info.Limits)
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	for name, data := range map[string]string{
+		"pkg.yaml": yamlManifest,
+		"pkg.yml":  yamlManifest,
+		"pkg.toml": tomlManifest,
+		"pkg.info": yamlManifest,
+	} {
+		info, err := installer.ParseManifest(name, []byte(data))
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		checkManifest(t, info)
+	}
+	info, err := installer.ParseManifest("pkg.info", []byte(tomlManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkManifest(t, info)
+}
+
+func TestManifestError(t *testing.T) {
+	for data, field := range map[string]string{
+		"name: test\nrestart:\n  backoff: soon\n":     "restart.backoff",
+		"name: test\nrestart:\n  policy: sometimes\n": "restart.policy",
+		"name: test\nappVersion: one\n":               "appVersion",
+		"appVersion: 1\n":                             "name",
+		"name: test\n  bad indent: 1\n":               "",
+	} {
+		_, err := installer.ParseManifest("pkg.yaml", []byte(data))
+		var merr *installer.ManifestError
+		if !errors.As(err, &merr) {
+			t.Fatal("expect ManifestError, got ", err)
+		}
+		if merr.Field != field || merr.File != "pkg.yaml" {
+			t.Fatal("expect field ", field, " got ", merr.Field, merr.File)
+		}
+		t.Log(err)
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+//go:build !race
+// +build !race
+
+package test
+
+const raceEnabled = false
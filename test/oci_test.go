@@ -0,0 +1,231 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type ociEntry struct {
+	name string
+	typ  byte
+	data string
+}
+
+func writeBlob(t *testing.T, layout string, data []byte) map[string]interface{} {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	err := ioutil.WriteFile(filepath.Join(layout, "blobs", "sha256", digest), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{
+		"digest": "sha256:" + digest,
+		"size":   len(data),
+	}
+}
+
+func writeLayer(t *testing.T, layout string, entries []ociEntry) map[string]interface{} {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		mode := int64(0644)
+		if e.typ == tar.TypeDir {
+			mode = 0755
+		}
+		err := tw.WriteHeader(&tar.Header{Typeflag: e.typ, Name: e.name, Mode: mode, Size: int64(len(e.data))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.data))
+	}
+	tw.Close()
+	gw.Close()
+	desc := writeBlob(t, layout, buf.Bytes())
+	desc["mediaType"] = "application/vnd.oci.image.layer.v1.tar+gzip"
+	return desc
+}
+
+// 创建OCI镜像布局，返回image manifest的摘要
+func createOCILayout(t *testing.T, layout string, annotations map[string]string, layers ...[]ociEntry) string {
+	err := os.MkdirAll(filepath.Join(layout, "blobs", "sha256"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := writeBlob(t, layout, []byte(`{"architecture": "amd64", "os": "linux"}`))
+	config["mediaType"] = "application/vnd.oci.image.config.v1+json"
+	var descs []interface{}
+	for _, l := range layers {
+		descs = append(descs, writeLayer(t, layout, l))
+	}
+	m, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        config,
+		"layers":        descs,
+		"annotations":   annotations,
+	})
+	manifest := writeBlob(t, layout, m)
+	manifest["mediaType"] = "application/vnd.oci.image.manifest.v1+json"
+	index, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests":     []interface{}{manifest},
+	})
+	ioutil.WriteFile(filepath.Join(layout, "index.json"), index, 0644)
+	ioutil.WriteFile(filepath.Join(layout, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644)
+	return manifest["digest"].(string)
+}
+
+func TestOCIInstall(t *testing.T) {
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := tempDir(t)
+	layout := filepath.Join(dir, "image")
+	digest := createOCILayout(t, layout, nil,
+		[]ociEntry{
+			{"pkg.info", tar.TypeReg, string(info)},
+			{"hello", tar.TypeReg, ""},
+			{"old.txt", tar.TypeReg, "old"},
+			{"conf/", tar.TypeDir, ""},
+			{"conf/a.conf", tar.TypeReg, "a"},
+			{"conf/b.conf", tar.TypeReg, "b"},
+		},
+		[]ociEntry{
+			{".wh.old.txt", tar.TypeReg, ""},
+			{"conf/", tar.TypeDir, ""},
+			{"conf/c.conf", tar.TypeReg, "c"},
+			{"conf/.wh..wh..opq", tar.TypeReg, ""},
+			{"new.txt", tar.TypeReg, "new"},
+		})
+
+	reg, err := installer.CreateRegistry(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, _, err := reg.Detect(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != installer.FormatOCI {
+		t.Fatal("expect oci, got ", format)
+	}
+	pi, err := reg.ReadInfo(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.GetName() != "test" {
+		t.Fatal("expect test, got ", pi.GetName())
+	}
+
+	pkg, err := reg.Install(layout, installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Uninstall(true)
+	if pkg.(*installer.ZipPackage).Digest != digest {
+		t.Fatal("expect ", digest, " got ", pkg.(*installer.ZipPackage).Digest)
+	}
+	for _, f := range []string{"pkg.info", "hello", "new.txt", "conf/c.conf"} {
+		if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"old.txt", "conf/a.conf", "conf/b.conf"} {
+		if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), f)); err == nil {
+			t.Fatal("expect removed: ", f)
+		}
+	}
+}
+
+func TestOCIAnnotation(t *testing.T) {
+	dir := tempDir(t)
+	layout := filepath.Join(dir, "image")
+	createOCILayout(t, layout, map[string]string{
+		installer.OCIInfoAnnotation: `{"name": "annotated", "appVersion": 1, "execName": "hello"}`,
+	}, []ociEntry{
+		{"hello", tar.TypeReg, ""},
+	})
+
+	inst, err := installer.CreateOCIInstaller(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := inst.Install(layout, installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Uninstall(true)
+	if pkg.GetName() != "annotated" {
+		t.Fatal("expect annotated, got ", pkg.GetName())
+	}
+	if _, err := os.Stat(filepath.Join(layout, "index.json")); err != nil {
+		t.Fatal("expect layout kept: ", err)
+	}
+
+	// 损坏的镜像层
+	blobs, _ := ioutil.ReadDir(filepath.Join(layout, "blobs", "sha256"))
+	for _, fi := range blobs {
+		d, _ := ioutil.ReadFile(filepath.Join(layout, "blobs", "sha256", fi.Name()))
+		if bytes.HasPrefix(d, []byte{0x1f, 0x8b}) {
+			ioutil.WriteFile(filepath.Join(layout, "blobs", "sha256", fi.Name()), append(d, 0), 0644)
+		}
+	}
+	_, err = inst.Install(layout, installer.NewStrategy())
+	if err == nil {
+		t.Fatal("expect digest error")
+	}
+	t.Log(err)
+}
+
+// OCI摘要只支持sha256及sha512，且必须声明算法
+func TestOCIDigestAlgorithm(t *testing.T) {
+	dir := tempDir(t)
+	layout := filepath.Join(dir, "image")
+	createOCILayout(t, layout, map[string]string{
+		installer.OCIInfoAnnotation: `{"name": "annotated", "appVersion": 1, "execName": "hello"}`,
+	}, []ociEntry{
+		{"hello", tar.TypeReg, ""},
+	})
+	index, err := ioutil.ReadFile(filepath.Join(layout, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := ioutil.ReadDir(filepath.Join(layout, "blobs", "sha256"))
+	os.MkdirAll(filepath.Join(layout, "blobs", "md5"), 0755)
+	for _, fi := range blobs {
+		d, _ := ioutil.ReadFile(filepath.Join(layout, "blobs", "sha256", fi.Name()))
+		sum := md5.Sum(d)
+		ioutil.WriteFile(filepath.Join(layout, "blobs", "md5", hex.EncodeToString(sum[:])), d, 0644)
+		if bytes.Contains(index, []byte(fi.Name())) {
+			for _, digest := range []string{"md5:" + hex.EncodeToString(sum[:]), fi.Name(), "sha256:" + fi.Name()[:32]} {
+				ioutil.WriteFile(filepath.Join(layout, "index.json"), bytes.Replace(index, []byte("sha256:"+fi.Name()), []byte(digest), 1), 0644)
+				inst, err := installer.CreateOCIInstaller(filepath.Join(dir, "target"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = inst.Install(layout, installer.NewStrategy())
+				if err == nil {
+					t.Fatal("expect invalid digest: ", digest)
+				}
+				t.Log(err)
+			}
+		}
+	}
+}
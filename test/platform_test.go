@@ -0,0 +1,102 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createPlatformZip(t *testing.T, path string, platforms string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+	files["pkg.info"] = `{"name": "multi", "appVersion": 1, "execName": "app", "protocolVersion": 2, "platforms": ` + platforms + `}`
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+}
+
+func TestPlatformInstall(t *testing.T) {
+	host := installer.HostPlatform()
+	other := "plan9-mips"
+	sum := sha256.Sum256([]byte("host app"))
+	dir := tempDir(t)
+	path := filepath.Join(dir, "multi.pkg")
+	createPlatformZip(t, path,
+		`{"`+host+`": {"execName": "app-host", "checksum": "sha256:`+hex.EncodeToString(sum[:])+`"}, "`+other+`": {}}`,
+		map[string]string{
+			"conf/app.conf":       "a=1",
+			host + "/app-host":    "host app",
+			host + "/lib/host.so": "host lib",
+			other + "/app":        "other app",
+		})
+
+	inst, err := installer.CreateInstaller(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := inst.Install(path, installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Uninstall(false)
+
+	if installer.GetPackageInfo(pkg).(*installer.ZipPackageInfo).ExecName != "app-host" {
+		t.Fatal("expect app-host, got ", installer.GetPackageInfo(pkg).(*installer.ZipPackageInfo).ExecName)
+	}
+	for _, f := range []string{"conf/app.conf", "app-host", "lib/host.so"} {
+		if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{host, other, "app"} {
+		if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), f)); err == nil {
+			t.Fatal("expect not installed: ", f)
+		}
+	}
+	d, _ := ioutil.ReadFile(filepath.Join(pkg.GetInstallPath(), "app-host"))
+	if string(d) != "host app" {
+		t.Fatal("expect host app, got ", string(d))
+	}
+}
+
+func TestPlatformNotSupported(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "multi.pkg")
+	createPlatformZip(t, path, `{"plan9-mips": {}}`, map[string]string{
+		"plan9-mips/app": "other app",
+	})
+
+	inst, err := installer.CreateInstaller(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = inst.Install(path, installer.NewStrategy())
+	var pe *installer.PlatformError
+	if !errors.As(err, &pe) {
+		t.Fatal("expect PlatformError, got ", err)
+	}
+	if pe.Platform != installer.HostPlatform() || len(pe.Supported) != 1 || pe.Supported[0] != "plan9-mips" {
+		t.Fatal("unexpected error: ", pe)
+	}
+	t.Log(err)
+}
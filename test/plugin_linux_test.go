@@ -0,0 +1,114 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/zip"
+	"errors"
+	"github.com/xfali/magnet"
+	"github.com/xfali/magnet/pkg/goplugin"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// 编译Go插件并打包为安装包
+func createPluginPackage(t *testing.T, path string, version int) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	dir := tempDir(t)
+	module := "plugin" + strconv.Itoa(version)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+module+"\n\ngo 1.14\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nvar Version = "+strconv.Itoa(version)+"\n\nfunc main() {}\n"), 0644)
+	out := filepath.Join(dir, "plugin.so")
+	args := []string{"build", "-buildmode=plugin", "-o", out}
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	cmd := exec.Command(gobin, append(args, ".")...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GO111MODULE=on", "CGO_ENABLED=1")
+	d, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Skip("build plugin failed: ", string(d))
+	}
+	so, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+	for name, data := range map[string][]byte{
+		"pkg.info":  []byte(`{"protocolVersion": 2, "name": "plugin", "appVersion": ` + strconv.Itoa(version) + `, "type": "goplugin", "execName": "plugin.so", "symbols": ["Version"]}`),
+		"plugin.so": so,
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+}
+
+// 已加载的插件无法升级，升级失败时保留已安装的插件
+func TestUpgradeLoadedPlugin(t *testing.T) {
+	dir := tempDir(t)
+	v1, v2 := filepath.Join(dir, "v1.pkg"), filepath.Join(dir, "v2.pkg")
+	createPluginPackage(t, v1, 1)
+	createPluginPackage(t, v2, 2)
+
+	r, err := installer.CreateJsonRecorder(filepath.Join(dir, "pkg.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := installer.CreateInstaller(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := magnet.New(magnet.SetRecorder(r), magnet.SetInstaller(inst))
+	defer m.Close()
+	pkg, err := m.Install(v1, magnet.InstallFlagNotExists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := m.Plugin("plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sym, _ := p.Lookup("Version"); *sym.(*int) != 1 {
+		t.Fatal("expect version 1, got ", *sym.(*int))
+	}
+
+	for _, flag := range []int{magnet.InstallFlagNewVersion | magnet.InstallFlagUninstallOld, magnet.InstallFlagForce} {
+		_, err = m.Install(v2, flag)
+		var rerr *goplugin.ReloadError
+		if !errors.As(err, &rerr) {
+			t.Fatal("expect ReloadError, got ", err)
+		}
+		t.Log(err)
+		if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), "plugin.so")); err != nil {
+			t.Fatal("expect installed plugin kept, got ", err)
+		}
+		if _, err := os.Stat(v2); err != nil {
+			t.Fatal("expect package kept, got ", err)
+		}
+		if len(m.GetPackage("plugin")) != 1 {
+			t.Fatal("expect version 1 recorded, got ", m.GetPackage("plugin"))
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"testing"
+)
+
+func TestProtocolMigrate(t *testing.T) {
+	d, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := installer.ParseManifest("pkg.info", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProtocolVersion != installer.CurrentProtocolVersion {
+		t.Fatal("expect current protocol version, got ", info.ProtocolVersion)
+	}
+	if info.Checksum != "md5:d41d8cd98f00b204e9800998ecf8427e" {
+		t.Fatal("expect md5 checksum migrated, got ", info.Checksum)
+	}
+
+	info, err = installer.ParseManifest("pkg.yaml", []byte("protocolVersion: 2\nname: test\n"+
+		"checksum: sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Checksum != "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Fatal("expect sha256 checksum kept, got ", info.Checksum)
+	}
+}
+
+func TestProtocolFuture(t *testing.T) {
+	_, err := installer.ParseManifest("pkg.yaml", []byte("protocolVersion: 99\nname: test\n"))
+	var perr *installer.ProtocolError
+	if !errors.As(err, &perr) {
+		t.Fatal("expect ProtocolError, got ", err)
+	}
+	if perr.Version != 99 {
+		t.Fatal("expect version 99, got ", perr.Version)
+	}
+	t.Log(err)
+}
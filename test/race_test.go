@@ -0,0 +1,12 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+//go:build race
+// +build race
+
+package test
+
+// 插件需使用与宿主相同的编译参数
+const raceEnabled = true
@@ -6,7 +6,10 @@
 package test
 
 import (
+	"encoding/json"
 	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 )
 
@@ -90,3 +93,34 @@ func TestJsonRecord2(t *testing.T) {
 		t.Log(v)
 	}
 }
+
+// 没有描述信息的旧版本安装记录在读取时从安装目录中的描述文件读取
+func TestRecordPackageInfo(t *testing.T) {
+	dir := tempDir(t)
+	installPath := filepath.Join(dir, "hello")
+	createIntegrityDir(t, installPath, nil, nil)
+	d, _ := json.Marshal(map[string]interface{}{
+		"integrity": []interface{}{map[string]interface{}{
+			"name":        "integrity",
+			"version":     1,
+			"installPath": installPath,
+		}},
+	})
+	recPath := filepath.Join(dir, "pkg.json")
+	err := ioutil.WriteFile(recPath, d, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := installer.CreateJsonRecorder(recPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := r.GetPackage("integrity")
+	if len(v) != 1 {
+		t.Fatal("expect 1 package, got ", len(v))
+	}
+	info, ok := installer.GetPackageInfo(v[0]).(*installer.ZipPackageInfo)
+	if !ok || info == nil || info.ExecName != "bin/app" {
+		t.Fatal("expect package info loaded, got ", info)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"compress/gzip"
+	"github.com/xfali/magnet/pkg/installer"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := tempDir(t)
+	tarPath := filepath.Join(dir, "hello.tgz")
+	createTar(t, tarPath, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	})
+
+	reg, err := installer.CreateRegistry(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expect := range map[string]string{
+		"./assets/hello.pkg": installer.FormatZip,
+		tarPath:              installer.FormatTar,
+	} {
+		format, _, err := reg.Detect(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != expect {
+			t.Fatal("expect ", expect, " got ", format)
+		}
+		pkg, err := reg.Install(path, installer.NewStrategy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkg.GetName() != "test" {
+			t.Fatal("expect test, got ", pkg.GetName())
+		}
+		err = reg.Uninstall(pkg, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, err = reg.Detect("./assets/pkg.info")
+	if err == nil {
+		t.Fatal("expect format not supported")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"github.com/xfali/magnet/pkg/installer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnsafeZipEntry(t *testing.T) {
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"../../evil", "conf/../../evil", "/tmp/evil", `..\evil`, "C:/evil"} {
+		t.Run(name, func(t *testing.T) {
+			dir := tempDir(t)
+			buf := &bytes.Buffer{}
+			zw := zip.NewWriter(buf)
+			for _, e := range []struct {
+				name string
+				data []byte
+			}{
+				{"pkg.info", info},
+				{"hello", nil},
+				{name, []byte("evil")},
+			} {
+				w, _ := zw.Create(e.name)
+				w.Write(e.data)
+			}
+			zw.Close()
+
+			installDir := filepath.Join(dir, "a", "target")
+			inst, err := installer.CreateInstaller(installDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkg, err := inst.InstallFrom(bytes.NewReader(buf.Bytes()), int64(buf.Len()), installer.NewStrategy())
+			var perr *installer.UnsafePathError
+			if !errors.As(err, &perr) {
+				t.Fatal("expect UnsafePathError, got ", err)
+			}
+			if pkg != nil {
+				t.Fatal("expect nil package")
+			}
+			t.Log(err)
+			// 不残留任何文件
+			files, _ := ioutil.ReadDir(installDir)
+			if len(files) != 0 {
+				t.Fatal("expect install dir empty, got ", len(files))
+			}
+			if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+				t.Fatal("expect evil not created")
+			}
+		})
+	}
+}
+
+func TestUnsafeTarEntry(t *testing.T) {
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]tar.Header{
+		"dotdot":          {{Typeflag: tar.TypeReg, Name: "../evil"}},
+		"absolute":        {{Typeflag: tar.TypeReg, Name: "/evil"}},
+		"symlink-abs":     {{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc"}},
+		"symlink-escape":  {{Typeflag: tar.TypeSymlink, Name: "conf/link", Linkname: "../../.."}},
+		"hardlink-escape": {{Typeflag: tar.TypeLink, Name: "passwd", Linkname: "../../etc/passwd"}},
+		// 父目录为指向自身的符号链接，按字面计算a/l位于安装目录之内
+		"symlink-parent": {
+			{Typeflag: tar.TypeSymlink, Name: "a", Linkname: "."},
+			{Typeflag: tar.TypeSymlink, Name: "a/l", Linkname: "../victim"},
+		},
+		"symlink-through-link": {
+			{Typeflag: tar.TypeSymlink, Name: "a", Linkname: "."},
+			{Typeflag: tar.TypeSymlink, Name: "l", Linkname: "a/../victim"},
+		},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "pkg.info", Mode: 0644, Size: int64(len(info))})
+			tw.Write(info)
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "hello", Mode: 0755})
+			for i := range entries {
+				entries[i].Mode = 0644
+				tw.WriteHeader(&entries[i])
+			}
+			tw.Close()
+
+			installDir := filepath.Join(tempDir(t), "target")
+			inst, err := installer.CreateTarInstaller(installDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = inst.InstallFrom(buf, -1, installer.NewStrategy())
+			var perr *installer.UnsafePathError
+			if !errors.As(err, &perr) {
+				t.Fatal("expect UnsafePathError, got ", err)
+			}
+			t.Log(err)
+			files, _ := ioutil.ReadDir(installDir)
+			if len(files) != 0 {
+				t.Fatal("expect install dir empty, got ", len(files))
+			}
+		})
+	}
+
+	// 指向安装目录之内的符号链接可以正常使用
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "pkg.info", Mode: 0644, Size: int64(len(info))})
+	tw.Write(info)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "hello", Mode: 0755})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "lib64/", Mode: 0755})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "lib", Linkname: "lib64"})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "lib/a.so", Mode: 0644})
+	tw.Close()
+	inst, err := installer.CreateTarInstaller(filepath.Join(tempDir(t), "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := inst.InstallFrom(buf, -1, installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), "lib64", "a.so")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/zip"
+	"github.com/xfali/magnet"
+	"github.com/xfali/magnet/pkg/installer"
+	"github.com/xfali/magnet/pkg/supervisor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createSchedulePackage(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+	for name, data := range map[string]string{
+		"pkg.info": `{"name": "cron", "appVersion": 1, "execCmd": "sh ${EXECUTABLE}", "execName": "run.sh", "schedule": {"cron": "@every 1s"}}`,
+		"run.sh":   "echo run\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+}
+
+// 卸载等待下次运行的定时任务后不再运行
+func TestUninstallScheduled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	pkgPath := filepath.Join(dir, "cron.pkg")
+	createSchedulePackage(t, pkgPath)
+
+	recorder, err := installer.CreateJsonRecorder(filepath.Join(dir, "pkg.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := magnet.New(magnet.Default(filepath.Join(dir, "target"), filepath.Join(dir, "pkg.rec")), magnet.SetRecorder(recorder))
+	defer m.Close()
+	for i := 0; i < 2; i++ {
+		_, err = m.Install(pkgPath, magnet.InstallFlagNotExists)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 重新安装后可以再次启动
+		err = m.Start("cron")
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := m.Status("cron")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.State != supervisor.StateScheduled {
+			t.Fatal("expect scheduled, got ", st.State)
+		}
+		err = m.Uninstall("cron", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err = m.Status("cron")
+		if err == nil && st.State != supervisor.StateStopped {
+			t.Fatal("expect stopped after uninstall, got ", st.State)
+		}
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	runs, _ := m.Runs("cron")
+	if len(runs) != 0 {
+		t.Fatal("expect no run after uninstall, got ", runs)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/xfali/magnet"
+	"github.com/xfali/magnet/pkg/installer"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 不实现io.ReaderAt的数据流
+type streamReader struct {
+	r io.Reader
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func TestInstallFromZip(t *testing.T) {
+	reg, err := installer.CreateRegistry(filepath.Join(tempDir(t), "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("./assets/hello.pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	// 实现io.ReaderAt，直接读取
+	pkg, err := reg.InstallFrom(f, fi.Size(), installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.GetName() != "test" {
+		t.Fatal("expect test, got ", pkg.GetName())
+	}
+	pkg.Uninstall(true)
+
+	// 写入临时文件后读取
+	f.Seek(0, io.SeekStart)
+	pkg, err = reg.InstallFrom(&streamReader{r: f}, -1, installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), "hello")); err != nil {
+		t.Fatal(err)
+	}
+	pkg.Uninstall(true)
+	if _, err := os.Stat("./assets/hello.pkg"); err != nil {
+		t.Fatal("expect package kept: ", err)
+	}
+}
+
+func TestInstallFromTar(t *testing.T) {
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	// 描述文件位于可执行文件之后
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{
+		{"hello", nil},
+		{"pkg.info", info},
+	} {
+		err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0755, Size: int64(len(e.data))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(e.data)
+	}
+	tw.Close()
+	gw.Close()
+
+	dir := filepath.Join(tempDir(t), "target")
+	reg, err := installer.CreateRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := reg.InstallFrom(&streamReader{r: buf}, -1, installer.NewStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Uninstall(true)
+	if _, err := os.Stat(filepath.Join(pkg.GetInstallPath(), "hello")); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatal("expect staging dir removed, got ", len(files))
+	}
+}
+
+// 只实现Installer的安装器，不支持从数据流安装
+type pathInstaller struct {
+	inst *installer.ZipInstaller
+}
+
+func (i *pathInstaller) ReadInfo(path string) (installer.PackageInfo, error) {
+	return i.inst.ReadInfo(path)
+}
+
+func (i *pathInstaller) Install(path string, strategy installer.Strategy) (installer.Package, error) {
+	return i.inst.Install(path, strategy)
+}
+
+func (i *pathInstaller) Uninstall(pkg installer.Package, delPkg bool) error {
+	return i.inst.Uninstall(pkg, delPkg)
+}
+
+func TestInstallFromUnsupported(t *testing.T) {
+	dir := tempDir(t)
+	inst, err := installer.CreateInstaller(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := installer.CreateJsonRecorder(filepath.Join(dir, "pkg.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := magnet.New(magnet.SetRecorder(r), magnet.SetInstaller(&pathInstaller{inst: inst}))
+	defer m.Close()
+	f, err := os.Open("./assets/hello.pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = m.InstallFrom(f, -1, magnet.InstallFlagNotExists)
+	if err == nil {
+		t.Fatal("expect install from stream not supported")
+	}
+	t.Log(err)
+}
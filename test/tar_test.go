@@ -0,0 +1,122 @@
+// Copyright (C) 2019-2020, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/xfali/magnet/pkg/installer"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTar(t *testing.T, path string, compress func(w io.Writer) (io.WriteCloser, error)) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cw, err := compress(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cw.Close()
+	tw := tar.NewWriter(cw)
+	defer tw.Close()
+
+	info, err := ioutil.ReadFile("./assets/pkg.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []struct {
+		hdr  tar.Header
+		data []byte
+	}{
+		{tar.Header{Typeflag: tar.TypeReg, Name: "pkg.info", Mode: 0644, ModTime: mtime}, info},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "hello", Mode: 0755, ModTime: mtime}, nil},
+		{tar.Header{Typeflag: tar.TypeDir, Name: "conf/", Mode: 0700, ModTime: mtime}, nil},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "conf/app.conf", Mode: 0600, ModTime: mtime}, []byte("a=1\n")},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "hello-link", Linkname: "hello", ModTime: mtime}, nil},
+	}
+	for _, e := range entries {
+		e.hdr.Size = int64(len(e.data))
+		err = tw.WriteHeader(&e.hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write(e.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTarInstall(t *testing.T) {
+	compressors := map[string]func(w io.Writer) (io.WriteCloser, error){
+		"tar.gz": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		"tar.xz": func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+		"tar.zst": func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+	}
+	for ext, c := range compressors {
+		t.Run(ext, func(t *testing.T) {
+			dir := tempDir(t)
+			path := filepath.Join(dir, "hello."+ext)
+			createTar(t, path, c)
+
+			inst, err := installer.CreateTarInstaller(filepath.Join(dir, "target"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := inst.ReadInfo(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.GetName() != "test" {
+				t.Fatal("expect test, got ", info.GetName())
+			}
+			pkg, err := inst.Install(path, installer.NewStrategy())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer inst.Uninstall(pkg, false)
+
+			fi, err := os.Stat(filepath.Join(pkg.GetInstallPath(), "hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0755 || !fi.ModTime().Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+				t.Fatal("expect mode and mtime preserved, got ", fi.Mode(), fi.ModTime())
+			}
+			fi, err = os.Stat(filepath.Join(pkg.GetInstallPath(), "conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0700 {
+				t.Fatal("expect dir mode 0700, got ", fi.Mode())
+			}
+			link, err := os.Readlink(filepath.Join(pkg.GetInstallPath(), "hello-link"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link != "hello" {
+				t.Fatal("expect symlink to hello, got ", link)
+			}
+		})
+	}
+}